@@ -20,6 +20,10 @@ func main() {
 	// If you want to save memory, use LogFile to dump logs to the file.
 	// handler := &accessprof.Handler{Handler: exampleHandler, ReportPath: "/accessprof", LogFile: "accessprof.ltsv"}
 
+	// If you don't want to expose the report on the application port, serve it on another listener.
+	// go http.ListenAndServe("localhost:8081", a.ReportHandler())
+	// http.ListenAndServe(":8080", a.Middleware(exampleHandler))
+
 	if err := http.ListenAndServe(":8080", handler); err != nil {
 		panic(err)
 	}
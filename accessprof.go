@@ -2,7 +2,7 @@ package accessprof
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/agatan/timejump"
@@ -25,6 +26,24 @@ type AccessLog struct {
 	ResponseBodySize int
 	ResponseTime     time.Duration
 	AccessedAt       time.Time
+	// Outbound is true for requests sent by a transport wrapped by AccessProf.WrapTransport
+	Outbound bool
+	// Host is the destination host of outbound requests
+	Host string
+	// Route is the route template of the request given by web frameworks (e.g. "/users/:id")
+	Route string
+	// Instance is the name of the process which recorded the request (see Shipper)
+	Instance string
+	// GRPCCode is the gRPC status code of RPCs (e.g. "NotFound"; see accessprofgrpc), whose Status is the equivalent HTTP status.
+	// It is empty for HTTP requests.
+	GRPCCode string
+	// InFlight is the number of requests being served by Handler (or middlewares calling AccessProf.Begin)
+	// when the request arrived, including itself (0 if unknown, e.g. for gRPC calls)
+	InFlight int
+	// RequestHeaders and ResponseHeaders are the headers captured by AccessProf.CaptureRequestHeaders and
+	// AccessProf.CaptureResponseHeaders, keyed by canonical header names
+	RequestHeaders  map[string]string
+	ResponseHeaders map[string]string
 }
 
 const (
@@ -34,33 +53,231 @@ const (
 	responseBodySizeLabel = "response_body_size"
 	responseTimeLabel     = "response_time_nano"
 	accessedAtLabel       = "accessed_at"
+	outboundLabel         = "outbound"
+	hostLabel             = "host"
+	routeLabel            = "route"
+	grpcCodeLabel         = "grpc_code"
+	instanceLabel         = "instance"
+	inFlightLabel         = "in_flight"
 )
 
 func (l *AccessLog) writeLTSV(w io.Writer) error {
-	_, err := fmt.Fprintf(w, "%s:%s\t%s:%s\t%s:%d\t%s:%d\t%s:%d\t%s:%s\n",
-		methodLabel, l.Method,
-		pathLabel, l.Path,
+	var optional string
+	if l.Outbound {
+		optional += fmt.Sprintf("\t%s:%t\t%s:%s", outboundLabel, l.Outbound, hostLabel, ltsvEscaper.Replace(l.Host))
+	}
+	if l.Route != "" {
+		optional += fmt.Sprintf("\t%s:%s", routeLabel, ltsvEscaper.Replace(l.Route))
+	}
+	if l.Instance != "" {
+		optional += fmt.Sprintf("\t%s:%s", instanceLabel, ltsvEscaper.Replace(l.Instance))
+	}
+	if l.GRPCCode != "" {
+		optional += fmt.Sprintf("\t%s:%s", grpcCodeLabel, ltsvEscaper.Replace(l.GRPCCode))
+	}
+	if l.InFlight != 0 {
+		optional += fmt.Sprintf("\t%s:%d", inFlightLabel, l.InFlight)
+	}
+	optional += l.headersLTSV()
+	_, err := fmt.Fprintf(w, "%s:%s\t%s:%s\t%s:%d\t%s:%d\t%s:%d\t%s:%s%s\n",
+		methodLabel, ltsvEscaper.Replace(l.Method),
+		pathLabel, ltsvEscaper.Replace(l.Path),
 		statusLabel, l.Status,
 		responseBodySizeLabel, l.ResponseBodySize,
 		responseTimeLabel, l.ResponseTime.Nanoseconds(),
 		accessedAtLabel, l.AccessedAt.Format(time.RFC3339Nano),
+		optional,
 	)
 	return errors.Wrap(err, "failed to write accesslog as ltsv")
 }
 
+// ltsvEscaper escapes tabs and newlines in LTSV values (e.g. paths requested as "/a%0Ab"),
+// which would otherwise break lines. ltsvUnescaper restores them.
+var (
+	ltsvEscaper   = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+	ltsvUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+)
+
 type AccessProf struct {
 	mu         sync.Mutex
 	accessLogs []*AccessLog
 	// LogFile is a filepath of the log file. (if empty, accessprof holds all logs on memory)
 	LogFile        string
 	FlushThreshold int
-	flushMu        sync.Mutex
+	// FlushInterval additionally flushes logs periodically, e.g. to ship them while traffic is low (disabled if 0).
+	// Call Close to stop it and flush the remaining logs on shutdown.
+	FlushInterval time.Duration
+	flushMu       sync.Mutex
+	// flushing is 1 while a flush started by Record is running
+	flushing    int32
+	flusherOnce sync.Once
+	stopFlusher chan struct{}
+	snapshots   []*Snapshot
+	// snapshotSeq numbers the log files of snapshots
+	snapshotSeq int
+	// MaxSnapshots is the number of snapshots kept by Cut (DefaultMaxSnapshots if 0)
+	MaxSnapshots int
+	// NormalizePaths replaces IDs, UUIDs, hashes, dates and tokens in paths with placeholders
+	// when the path matches none of aggregates (see NormalizePath)
+	NormalizePaths bool
+	// MaxSegments limits the number of segments in a report (unlimited if 0).
+	// Logs which would make new segments beyond the limit are put into overflow segments of OtherMethod and OtherPath,
+	// one for each status.
+	// The limits are applied both when requests are recorded, so that logs in memory don't hold unbounded
+	// distinct paths, and when reports are built with aggregates.
+	MaxSegments int
+	// MaxPaths limits the number of distinct paths (or aggregates) in a report (unlimited if 0).
+	// Paths beyond the limit are reported as OtherPath.
+	MaxPaths int
+	// MaxMethods limits the number of distinct methods in a report (unlimited if 0).
+	// Methods beyond the limit are reported as OtherMethod.
+	MaxMethods int
+	// recorded is the distinct paths, methods and segments recorded in the current window (see limitLog)
+	recorded recordedValues
+	// Shipper sends flushed logs to a collector instead of writing them to LogFile (ignored if nil).
+	// Shipped logs are no longer in the report of this AccessProf; see the report of the collector.
+	// Reports don't flush logs to Shipper, so that they don't wait for the collector.
+	Shipper *Shipper
+	// SLO is the latency targets of the Apdex and SLO columns of reports (the columns are empty if nil)
+	SLO *SLO
+	// AlertRules are evaluated over recorded inbound requests every AlertInterval, and OnAlert is called
+	// when a rule trips or resolves. Rules are evaluated only if OnAlert is set.
+	// Set them before recording requests; call Close to stop evaluating them.
+	AlertRules []*AlertRule
+	// OnAlert is called with alerts in order on a separate goroutine (see WebhookAlert)
+	OnAlert func(*Alert)
+	// AlertInterval is the interval of evaluating AlertRules (DefaultAlertInterval if 0)
+	AlertInterval time.Duration
+	alertOnce     sync.Once
+	alertStates   map[*AlertRule]*alertState
+	stopAlerts    chan struct{}
+	droppedAlerts int64
+	// CaptureRequestHeaders and CaptureResponseHeaders are the names of headers recorded into access logs
+	// by Handler, Middleware and the middlewares calling Begin (e.g. "Content-Type", "X-Request-ID")
+	CaptureRequestHeaders  []string
+	CaptureResponseHeaders []string
+	// GroupByHeaders splits segments by values of captured headers, qualified by "req:" or "res:"
+	// (e.g. "res:Content-Encoding")
+	GroupByHeaders []string
+	// Filter decides which requests are recorded (all requests if nil)
+	Filter *Filter
+	// Authorize decides whether a request may access the report (everyone if nil).
+	// See BasicAuth and BearerToken.
+	Authorize func(*http.Request) bool
+	// AuthChallenge is the WWW-Authenticate header of responses to requests rejected by Authorize
+	// (none if empty). Set BasicAuthChallenge with BasicAuth to let browsers prompt for credentials.
+	AuthChallenge string
+	// AuthorizeReset additionally decides whether a request may reset the access logs (everyone allowed by Authorize if nil)
+	AuthorizeReset func(*http.Request) bool
+	csrfOnce       sync.Once
+	csrfToken      string
+	// live caches the payloads of the live report stream (see liveEvent)
+	live liveEvents
+	// inFlight is the number of requests being served by Handler, Middleware and middlewares calling Begin
+	inFlight int32
 }
 
 func (a *AccessProf) Wrap(h http.Handler, reportPath string) *Handler {
 	return &Handler{Handler: h, ReportPath: reportPath, AccessProf: a}
 }
 
+// Record appends l to the access logs unless Filter rejects it,
+// and flushes them to LogFile in background if they exceed FlushThreshold.
+// It is used to record requests which are not served by Handler, e.g. gRPC calls.
+func (a *AccessProf) Record(l *AccessLog) {
+	if !a.Filter.allowRequest(l.Method, l.Path) {
+		return
+	}
+	a.record(l)
+}
+
+// Begin starts recording l of a request served by middlewares of other frameworks
+// (see accessprofecho, accessprofgin and accessproffiber), unless Filter rejects it.
+// header returns a request header by name, e.g. (http.Header).Get.
+// If Begin returns true, the request is counted in flight like requests served by Handler,
+// l.InFlight and l.RequestHeaders (see CaptureRequestHeaders) are set; defer Finish to record it.
+func (a *AccessProf) Begin(l *AccessLog, header func(name string) string) bool {
+	if !a.Filter.Allow(l.Method, l.Path, header("User-Agent")) || !a.Filter.allowHeaders(header) {
+		return false
+	}
+	l.InFlight = int(atomic.AddInt32(&a.inFlight, 1))
+	l.RequestHeaders = captureHeaders(header, a.CaptureRequestHeaders)
+	return true
+}
+
+// Finish records l started by Begin, and stops counting the request in flight.
+// header returns a response header by name to capture CaptureResponseHeaders (none if nil).
+// It is meant to be deferred, so that a panic in the handler doesn't leave the request in flight;
+// such a request is recorded with status 0, like requests which failed without a response.
+func (a *AccessProf) Finish(l *AccessLog, header func(name string) string) {
+	atomic.AddInt32(&a.inFlight, -1)
+	if l.ResponseTime == 0 {
+		l.ResponseTime = timejump.Now().Sub(l.AccessedAt)
+	}
+	if header != nil {
+		l.ResponseHeaders = captureHeaders(header, a.CaptureResponseHeaders)
+	}
+	a.record(l)
+}
+
+// record appends l to the access logs of requests which already passed Filter.
+func (a *AccessProf) record(l *AccessLog) {
+	a.observeAlerts(l)
+	if a.FlushInterval > 0 {
+		a.flusherOnce.Do(a.startFlusher)
+	}
+	a.mu.Lock()
+	a.limitLog(l)
+	a.accessLogs = append(a.accessLogs, l)
+	full := len(a.accessLogs) > a.FlushThreshold || a.FlushThreshold == 0 && len(a.accessLogs) > DefaultFlushThreshold
+	a.mu.Unlock()
+	// start at most one flush at a time, so that a slow LogFile or collector doesn't pile up goroutines
+	if full && atomic.CompareAndSwapInt32(&a.flushing, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&a.flushing, 0)
+			a.flushLogs()
+		}()
+	}
+}
+
+// startFlusher flushes logs every FlushInterval until Close is called.
+func (a *AccessProf) startFlusher() {
+	stop := make(chan struct{})
+	a.mu.Lock()
+	a.stopFlusher = stop
+	a.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(a.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				a.flushLogs()
+			}
+		}
+	}()
+}
+
+// Close stops flushing logs every FlushInterval and evaluating AlertRules,
+// and flushes the remaining logs to LogFile or Shipper. Call it on shutdown not to lose the logs in memory.
+func (a *AccessProf) Close() error {
+	// never start the flusher and alerts after Close
+	a.flusherOnce.Do(func() {})
+	a.alertOnce.Do(func() {})
+	a.mu.Lock()
+	stops := []chan struct{}{a.stopFlusher, a.stopAlerts}
+	a.stopFlusher, a.stopAlerts = nil, nil
+	a.mu.Unlock()
+	for _, stop := range stops {
+		if stop != nil {
+			close(stop)
+		}
+	}
+	return a.flushLogs()
+}
+
 func (a *AccessProf) Count() int {
 	a.mu.Lock()
 	n := len(a.accessLogs)
@@ -69,7 +286,9 @@ func (a *AccessProf) Count() int {
 }
 
 func (a *AccessProf) Report(aggregates []*regexp.Regexp) *Report {
-	a.flushLogs()
+	if a.Shipper == nil {
+		a.flushLogs()
+	}
 	logs, err := a.LoadAccessLogs()
 	if err != nil {
 		panic(err)
@@ -79,46 +298,223 @@ func (a *AccessProf) Report(aggregates []*regexp.Regexp) *Report {
 	logs = append(logs, a.accessLogs...)
 	a.mu.Unlock()
 
+	return buildReport(logs, aggregates, a.reportOptions())
+}
+
+// reportOptions controls how access logs are grouped into report segments.
+type reportOptions struct {
+	normalizePaths bool
+	maxSegments    int
+	maxPaths       int
+	maxMethods     int
+	slo            *SLO
+	groupByHeaders []string
+}
+
+func (a *AccessProf) reportOptions() reportOptions {
+	return reportOptions{
+		normalizePaths: a.NormalizePaths,
+		maxSegments:    a.MaxSegments,
+		maxPaths:       a.MaxPaths,
+		maxMethods:     a.MaxMethods,
+		slo:            a.SLO,
+		groupByHeaders: a.GroupByHeaders,
+	}
+}
+
+const (
+	// OtherPath is the path of segments for paths beyond AccessProf.MaxPaths or AccessProf.MaxSegments
+	OtherPath = "(other)"
+	// OtherMethod is the method of segments for methods beyond AccessProf.MaxMethods or AccessProf.MaxSegments
+	OtherMethod = "OTHER"
+)
+
+type recordedValues struct {
+	paths, methods, segments map[string]bool
+}
+
+// fits reports whether v is already in seen or seen has less than max values.
+func fits(seen map[string]bool, v string, max int) bool {
+	return max <= 0 || seen[v] || len(seen) < max
+}
+
+// limitLog replaces the path and method of l with OtherPath and OtherMethod
+// if they would exceed MaxPaths, MaxMethods or MaxSegments in the current window.
+// The caller must hold mu.
+func (a *AccessProf) limitLog(l *AccessLog) {
+	if a.MaxSegments <= 0 && a.MaxPaths <= 0 && a.MaxMethods <= 0 {
+		return
+	}
+	if a.recorded.paths == nil {
+		a.recorded = recordedValues{paths: map[string]bool{}, methods: map[string]bool{}, segments: map[string]bool{}}
+	}
+	path := l.Path
+	if l.Route != "" {
+		path = l.Route
+	} else if a.NormalizePaths {
+		path = NormalizePath(path)
+	}
+	if !fits(a.recorded.paths, path, a.MaxPaths) {
+		path, l.Path, l.Route = OtherPath, OtherPath, ""
+	}
+	if !fits(a.recorded.methods, l.Method, a.MaxMethods) {
+		l.Method = OtherMethod
+	}
+	key := l.Method + " " + strconv.Itoa(l.Status) + " " + path
+	if l.Outbound {
+		key = "outbound " + l.Host + " " + key
+	}
+	if headers := l.groupingHeaders(a.GroupByHeaders); headers != nil {
+		key += " " + formatHeaders(headers)
+	}
+	if !fits(a.recorded.segments, key, a.MaxSegments) {
+		l.Method, l.Path, l.Route = OtherMethod, OtherPath, ""
+		return
+	}
+	if path != OtherPath {
+		a.recorded.paths[path] = true
+	}
+	if l.Method != OtherMethod {
+		a.recorded.methods[l.Method] = true
+	}
+	a.recorded.segments[key] = true
+}
+
+// limitCardinality returns v if it is already in seen or seen has less than max values, or other otherwise.
+func limitCardinality(seen map[string]bool, v string, max int, other string) (string, bool) {
+	if max <= 0 || seen[v] {
+		return v, true
+	}
+	if len(seen) >= max {
+		return other, false
+	}
+	seen[v] = true
+	return v, true
+}
+
+func buildReport(logs []*AccessLog, aggregates []*regexp.Regexp, opts reportOptions) *Report {
 	var (
-		segs  []*ReportSegment
-		since time.Time
+		segs      []*ReportSegment
+		since     time.Time
+		until     time.Time
+		overflows = map[string]*ReportSegment{}
+		// overflowSegs are the values of overflows in order, appended to segs at last
+		overflowSegs []*ReportSegment
+		overflowed   int
 	)
 
+	index := map[string]*ReportSegment{}
+	paths := map[string]bool{}
+	methods := map[string]bool{}
 	for _, l := range logs {
 		if since.IsZero() || since.After(l.AccessedAt) {
 			since = l.AccessedAt
 		}
-		newsegment := true
-		for _, seg := range segs {
-			if seg.match(l) {
-				seg.add(l)
-				newsegment = false
+		if end := l.AccessedAt.Add(l.ResponseTime); until.Before(end) {
+			until = end
+		}
+		// logs limited by AccessProf.limitLog keep OtherPath and OtherMethod regardless of aggregates
+		limitedPath, limitedMethod := l.Path == OtherPath, l.Method == OtherMethod
+		if limitedPath && limitedMethod {
+			overflowed++
+			addOverflow(&overflowSegs, overflows, l)
+			continue
+		}
+		var pathRegexp *regexp.Regexp
+		for _, agg := range aggregates {
+			if !limitedPath && agg.MatchString(l.Path) {
+				pathRegexp = agg
 				break
 			}
 		}
-		if newsegment {
-			seg := &ReportSegment{
-				Method:     l.Method,
-				Path:       l.Path,
-				Status:     l.Status,
-				AccessLogs: []*AccessLog{l},
+		path := l.Path
+		if pathRegexp == nil && l.Route != "" {
+			path = l.Route
+		} else if pathRegexp == nil && opts.normalizePaths {
+			path = NormalizePath(path)
+		}
+		pathKey := "path:" + path
+		if pathRegexp != nil {
+			pathKey = "regexp:" + pathRegexp.String()
+		}
+		pathOK := !limitedPath
+		if pathOK {
+			pathKey, pathOK = limitCardinality(paths, pathKey, opts.maxPaths, "other")
+		}
+		if !pathOK {
+			path, pathRegexp, pathKey = OtherPath, nil, "other"
+		}
+		method, methodOK := l.Method, !limitedMethod
+		if methodOK {
+			method, methodOK = limitCardinality(methods, l.Method, opts.maxMethods, OtherMethod)
+		}
+		if !pathOK || !methodOK {
+			overflowed++
+		}
+
+		key := method + " " + strconv.Itoa(l.Status) + " " + pathKey
+		if l.GRPCCode != "" {
+			key += " grpc:" + l.GRPCCode
+		}
+		if l.Outbound {
+			key = "outbound " + l.Host + " " + key
+		}
+		headers := l.groupingHeaders(opts.groupByHeaders)
+		if headers != nil {
+			key += " " + formatHeaders(headers)
+		}
+		seg, ok := index[key]
+		if !ok && opts.maxSegments > 0 && len(index) >= opts.maxSegments {
+			if pathOK && methodOK {
+				overflowed++
 			}
-			for _, agg := range aggregates {
-				if agg.MatchString(l.Path) {
-					seg.PathRegexp = agg
-					break
-				}
+			addOverflow(&overflowSegs, overflows, l)
+			continue
+		}
+		if !ok {
+			seg = &ReportSegment{
+				Method:     method,
+				Path:       path,
+				PathRegexp: pathRegexp,
+				Status:     l.Status,
+				GRPCCode:   l.GRPCCode,
+				Outbound:   l.Outbound,
+				Host:       l.Host,
+				Headers:    headers,
 			}
+			index[key] = seg
 			segs = append(segs, seg)
 		}
+		seg.add(l)
 	}
+	segs = append(segs, overflowSegs...)
 
-	return &Report{Segments: segs, Aggregates: aggregates, Since: since}
+	report := &Report{Segments: segs, Aggregates: aggregates, Since: since, Until: until, Overflowed: overflowed}
+	report.setWindow()
+	report.SetSLO(opts.slo)
+	return report
+}
+
+// addOverflow adds the statistics of l to the overflow segment of its status and destination.
+// Overflow segments don't keep raw access logs, which would otherwise hold unbounded distinct paths.
+func addOverflow(segs *[]*ReportSegment, overflows map[string]*ReportSegment, l *AccessLog) {
+	key := strconv.Itoa(l.Status)
+	if l.Outbound {
+		key = "outbound " + l.Host + " " + key
+	}
+	seg, ok := overflows[key]
+	if !ok {
+		seg = &ReportSegment{Method: OtherMethod, Path: OtherPath, Status: l.Status, Outbound: l.Outbound, Host: l.Host}
+		overflows[key] = seg
+		*segs = append(*segs, seg)
+	}
+	seg.addStats(l)
 }
 
 func (a *AccessProf) Reset() {
 	a.mu.Lock()
 	a.accessLogs = a.accessLogs[:0]
+	a.recorded = recordedValues{}
 	a.mu.Unlock()
 	if a.LogFile != "" {
 		a.flushMu.Lock()
@@ -128,7 +524,7 @@ func (a *AccessProf) Reset() {
 }
 
 func (a *AccessProf) flushLogs() (err error) {
-	if a.LogFile == "" {
+	if a.LogFile == "" && a.Shipper == nil {
 		return nil
 	}
 	a.flushMu.Lock()
@@ -139,6 +535,17 @@ func (a *AccessProf) flushLogs() (err error) {
 	a.accessLogs = nil
 	a.mu.Unlock()
 
+	if a.Shipper != nil {
+		err := a.Shipper.Ship(logs)
+		if retry := a.Shipper.retryLogs(logs, err); len(retry) != 0 {
+			// keep the logs to retry at the next flush
+			a.mu.Lock()
+			a.accessLogs = append(retry, a.accessLogs...)
+			a.mu.Unlock()
+		}
+		return err
+	}
+
 	f, err := os.OpenFile(a.LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		return errors.Wrap(err, "failed to open log file")
@@ -164,9 +571,17 @@ func (a *AccessProf) LoadAccessLogs() ([]*AccessLog, error) {
 	}
 	a.flushMu.Lock()
 	defer a.flushMu.Unlock()
+	return readLogFile(a.LogFile)
+}
+
+// readLogFile reads all logs dumped to path. The caller must hold flushMu if path is LogFile.
+func readLogFile(path string) ([]*AccessLog, error) {
 	var logs []*AccessLog
-	f, err := os.Open(a.LogFile)
+	f, err := os.Open(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
 		return nil, errors.Wrap(err, "failed to open access logs")
 	}
 	defer f.Close()
@@ -191,7 +606,10 @@ func parseLTSV(s string) (*AccessLog, error) {
 	table := map[string]string{}
 	for _, column := range columns {
 		ss := strings.SplitN(column, ":", 2)
-		table[ss[0]] = ss[1]
+		if len(ss) != 2 {
+			return nil, errors.Errorf("malformed column %q", column)
+		}
+		table[ss[0]] = ltsvUnescaper.Replace(ss[1])
 	}
 	l := new(AccessLog)
 	if s, ok := table[methodLabel]; ok {
@@ -240,6 +658,25 @@ func parseLTSV(s string) (*AccessLog, error) {
 	} else {
 		return nil, errors.New("missing accessed_at label")
 	}
+	if s, ok := table[outboundLabel]; ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse outbound")
+		}
+		l.Outbound = b
+		l.Host = table[hostLabel]
+	}
+	l.Route = table[routeLabel]
+	l.GRPCCode = table[grpcCodeLabel]
+	l.Instance = table[instanceLabel]
+	if s, ok := table[inFlightLabel]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse in_flight")
+		}
+		l.InFlight = n
+	}
+	l.parseHeaders(table)
 	return l, nil
 }
 
@@ -256,61 +693,49 @@ const (
 )
 
 func (a *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if a.ReportPath != "" && r.URL.Path == a.ReportPath {
-		if r.Method == http.MethodDelete {
-			a.Reset()
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		if r.Method == http.MethodGet {
-			a.serveReportRequest(w, r)
-			return
-		}
+	// other methods at ReportPath are served by the base handler (see ReportHandler)
+	reportMethod := r.Method == http.MethodGet || r.Method == http.MethodPost || r.Method == http.MethodDelete
+	if a.ReportPath != "" && r.URL.Path == a.ReportPath && reportMethod {
+		a.ReportHandler().ServeHTTP(w, r)
+		return
+	}
+	a.serveAndRecord(w, r, a.Handler)
+}
+
+// Middleware records requests served by next. Unlike Handler, it does not serve the report;
+// mount ReportHandler separately. It can be used as a func(http.Handler) http.Handler middleware.
+func (a *AccessProf) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.serveAndRecord(w, r, next)
+	})
+}
+
+type accessLogKey struct{}
+
+// SetRoute sets the route template (e.g. "/users/:id") of the request being recorded by Handler or Middleware.
+// Requests are aggregated by their route templates unless their paths match any of aggregates.
+func SetRoute(r *http.Request, route string) {
+	if l, ok := r.Context().Value(accessLogKey{}).(*AccessLog); ok {
+		l.Route = route
 	}
+}
+
+func (a *AccessProf) serveAndRecord(w http.ResponseWriter, r *http.Request, h http.Handler) {
 	l := &AccessLog{
 		Method:          r.Method,
 		Path:            r.URL.Path,
 		RequestBodySize: r.ContentLength,
 		AccessedAt:      timejump.Now(),
 	}
+	if !a.Begin(l, r.Header.Get) {
+		h.ServeHTTP(w, r)
+		return
+	}
+	defer a.Finish(l, w.Header().Get)
 	start := timejump.Now()
 	wrapped := responseWriter{w: w}
-	a.Handler.ServeHTTP(&wrapped, r)
+	h.ServeHTTP(&wrapped, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, l)))
 	l.ResponseTime = timejump.Now().Sub(start)
 	l.Status = wrapped.status
 	l.ResponseBodySize = wrapped.writtenSize
-	a.mu.Lock()
-	a.accessLogs = append(a.accessLogs, l)
-	if len(a.accessLogs) > a.FlushThreshold || a.FlushThreshold == 0 && len(a.accessLogs) > DefaultFlushThreshold {
-		go a.flushLogs()
-	}
-	a.mu.Unlock()
-}
-
-func (a *Handler) serveReportRequest(w http.ResponseWriter, r *http.Request) {
-	if err := r.Body.Close(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		return
-	}
-	aggparam := r.URL.Query().Get("agg")
-	var aggs []*regexp.Regexp
-	if aggparam != "" {
-		for _, agg := range strings.Split(aggparam, ",") {
-			re, err := regexp.Compile("^" + agg + "$")
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				body, _ := json.Marshal(map[string]string{
-					"error": fmt.Sprintf("failed to compile regexp %q: %v", re, err),
-				})
-				w.Write(body)
-				return
-			}
-			aggs = append(aggs, re)
-		}
-	}
-	if err := a.Report(aggs).RenderHTML(w, a.ReportPath); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-	}
 }
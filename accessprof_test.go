@@ -1,14 +1,21 @@
 package accessprof
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var testHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +38,22 @@ func TestAccessProf_Wrap_recordsRequests(t *testing.T) {
 	}
 }
 
+func TestAccessProf_Wrap_otherMethodsAtReportPath(t *testing.T) {
+	var accessProf AccessProf
+	server := httptest.NewServer(accessProf.Wrap(testHandler, "/accessprof"))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/accessprof", strings.NewReader("body"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || accessProf.Count() != 1 {
+		t.Fatalf("PUT at the report path should be served by the base handler, but got status %d and %d access logs", resp.StatusCode, accessProf.Count())
+	}
+}
+
 func TestAccessProf_MakeReport_aggregatesByMethod(t *testing.T) {
 	var a AccessProf
 	server := httptest.NewServer(a.Wrap(testHandler, ""))
@@ -154,3 +177,496 @@ func TestAccessProf_Report_DumpToFile(t *testing.T) {
 		t.Fatalf("Report should read from dump file, but it doesn't work: got %d segments", len(report.Segments))
 	}
 }
+
+func TestAccessProf_Cut(t *testing.T) {
+	a := AccessProf{LogFile: "ltsv"}
+	defer os.Remove("ltsv")
+	server := httptest.NewServer(a.Wrap(testHandler, ""))
+	defer server.Close()
+
+	http.Get(server.URL)
+	http.Get(server.URL + "/test")
+	a.flushLogs()
+	http.Get(server.URL + "/test")
+
+	s, err := a.Cut("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Count() != 3 {
+		t.Fatalf("snapshot should hold all logs recorded so far, but got %d logs", s.Count())
+	}
+	if report := a.Report(nil); report.RequestCount() != 0 {
+		t.Fatalf("Cut should start a fresh window, but got %d requests", report.RequestCount())
+	}
+
+	http.Get(server.URL)
+	if _, err := a.Cut("first"); err == nil {
+		t.Fatal("Cut should fail if the snapshot name is already used")
+	}
+	if _, err := a.Cut("second"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Snapshots()) != 2 || a.Snapshot("second").Count() != 1 {
+		t.Fatalf("unexpected snapshots: %v", a.Snapshots())
+	}
+
+	c := CompareReports(a.Snapshot("first").Report(nil), a.Snapshot("second").Report(nil))
+	if len(c.Rows) != 2 {
+		t.Fatalf("expected 2 comparison rows, GET / and GET /test; but got %d", len(c.Rows))
+	}
+	if _, ok := c.Rows[1].AvgResponseTimeChange(); ok {
+		t.Fatal("GET /test is missing in the second snapshot, but got the change of response time")
+	}
+
+	if err := a.DeleteSnapshot("first"); err != nil {
+		t.Fatal(err)
+	}
+	if a.Snapshot("first") != nil || len(a.Snapshots()) != 1 {
+		t.Fatalf("the snapshot should be deleted, but got %d snapshots", len(a.Snapshots()))
+	}
+	if err := a.DeleteSnapshot("first"); err == nil {
+		t.Fatal("deleting an unknown snapshot should fail")
+	}
+	a.Snapshot("second").remove()
+}
+
+func TestAccessProf_Cut_logFile(t *testing.T) {
+	a := AccessProf{LogFile: "ltsv", MaxSnapshots: 2}
+	defer os.Remove("ltsv")
+
+	for i, name := range []string{"first", "second", "third"} {
+		for j := 0; j <= i; j++ {
+			a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200})
+		}
+		// some logs are flushed to LogFile, and others are in memory
+		if err := a.flushLogs(); err != nil {
+			t.Fatal(err)
+		}
+		a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200})
+		s, err := a.Cut(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.remove()
+		if len(s.accessLogs) != 0 {
+			t.Fatal("the logs of snapshots should not be kept in memory with LogFile")
+		}
+		if s.Count() != i+2 || s.Report(nil).RequestCount() != i+2 {
+			t.Fatalf("expected %d logs in the snapshot %s, but got %d", i+2, name, s.Count())
+		}
+	}
+	if a.Report(nil).RequestCount() != 0 {
+		t.Fatal("Cut should start a fresh window")
+	}
+
+	snapshots := a.Snapshots()
+	if len(snapshots) != 2 || snapshots[0].Name != "second" {
+		t.Fatalf("expected the oldest snapshot to be deleted beyond MaxSnapshots, but got %v", snapshots)
+	}
+	if _, err := os.Stat("ltsv.snapshot-1"); !os.IsNotExist(err) {
+		t.Fatalf("the log file of the deleted snapshot should be removed, but got %v", err)
+	}
+}
+
+func TestAccessProf_Cut_concurrent(t *testing.T) {
+	a := AccessProf{}
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		cut int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.Cut("x"); err == nil {
+				mu.Lock()
+				cut++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if cut != 1 || len(a.Snapshots()) != 1 {
+		t.Fatalf("expected only one snapshot named x, but got %d cuts and %d snapshots", cut, len(a.Snapshots()))
+	}
+}
+
+func TestAccessProf_ServeHTTP_cutSnapshot(t *testing.T) {
+	var a AccessProf
+	server := httptest.NewServer(a.Wrap(testHandler, "/accessprof"))
+	defer server.Close()
+
+	http.Get(server.URL)
+	resp, err := http.PostForm(server.URL+"/accessprof", url.Values{"name": {"bench-1"}})
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Snapshot request is failed: error %v, status code %d", err, resp.StatusCode)
+	}
+	if a.Count() != 0 || a.Snapshot("bench-1") == nil {
+		t.Fatal("Snapshot request should cut the current window")
+	}
+
+	for _, query := range []string{"?snapshot=bench-1", "?base=bench-1", "?base=bench-1&snapshot=bench-1"} {
+		resp, err := http.Get(server.URL + "/accessprof" + query)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("Report request %q is failed: error %v, status code %d", query, err, resp.StatusCode)
+		}
+	}
+	resp, err = http.Get(server.URL + "/accessprof?snapshot=unknown")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Report request for unknown snapshot should be 404: error %v, status code %d", err, resp.StatusCode)
+	}
+
+	http.Get(server.URL)
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/accessprof?snapshot=bench-1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Delete request is failed: error %v, status code %d", err, resp.StatusCode)
+	}
+	if a.Snapshot("bench-1") != nil || a.Count() != 1 {
+		t.Fatal("Delete request with snapshot should delete only the snapshot")
+	}
+}
+
+func TestAccessProf_Middleware(t *testing.T) {
+	var a AccessProf
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r, "/users/:id")
+		w.Write([]byte("user"))
+	})
+	app := httptest.NewServer(a.Middleware(mux))
+	defer app.Close()
+	admin := httptest.NewServer(a.ReportHandler())
+	defer admin.Close()
+
+	http.Get(app.URL + "/users/1")
+	http.Get(app.URL + "/users/2")
+
+	report := a.Report(nil)
+	if len(report.Segments) != 1 || report.Segments[0].Path != "/users/:id" || report.Segments[0].Count() != 2 {
+		t.Fatalf("requests should be aggregated by the route, but got %v", report.Segments)
+	}
+
+	resp, err := http.Get(admin.URL + "/anywhere")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("ReportHandler should serve the report at any path: error %v, status code %d", err, resp.StatusCode)
+	}
+	if a.Count() != 2 {
+		t.Fatal("requests to ReportHandler should not be recorded")
+	}
+}
+
+func TestAccessProf_ReportHandler_underPrefix(t *testing.T) {
+	var a AccessProf
+	mux := http.NewServeMux()
+	mux.Handle("/admin/accessprof/", http.StripPrefix("/admin/accessprof", a.ReportHandler()))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/admin/accessprof/", url.Values{"name": {"snap"}})
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Snapshot request is failed: error %v, status code %d", err, resp.StatusCode)
+	}
+	resp, err = http.Get(server.URL + "/admin/accessprof/")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Report request is failed: error %v, status code %d", err, resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `href="?snapshot=snap"`) || strings.Contains(string(body), `action="/`) {
+		t.Fatalf("the report page should link to itself by relative URLs:\n%s", body)
+	}
+}
+
+func TestAccessProf_Filter(t *testing.T) {
+	a := &AccessProf{Filter: &Filter{
+		ExcludePathPrefixes: []string{"/static/"},
+		ExcludeMethods:      []string{"OPTIONS"},
+		ExcludeUserAgents:   []*regexp.Regexp{regexp.MustCompile("HealthChecker")},
+	}}
+	server := httptest.NewServer(a.Wrap(testHandler, ""))
+	defer server.Close()
+
+	http.Get(server.URL + "/users")
+	http.Get(server.URL + "/static/app.js")
+	req, _ := http.NewRequest("OPTIONS", server.URL+"/users", nil)
+	http.DefaultClient.Do(req)
+	req, _ = http.NewRequest("GET", server.URL+"/health", nil)
+	req.Header.Set("User-Agent", "ELB-HealthChecker/2.0")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("filtered requests should be served, but got status %d", res.StatusCode)
+	}
+
+	if a.Count() != 1 {
+		t.Fatalf("expected only GET /users to be recorded, but got %d access logs", a.Count())
+	}
+}
+
+func TestFilter_Allow_include(t *testing.T) {
+	f := &Filter{
+		IncludePathPrefixes: []string{"/api/"},
+		ExcludePathRegexps:  []*regexp.Regexp{regexp.MustCompile(`^/api/internal/`)},
+		IncludeMethods:      []string{"GET", "POST"},
+		IncludeUserAgents:   []*regexp.Regexp{regexp.MustCompile(`^Mozilla/`)},
+	}
+	for _, c := range []struct {
+		method    string
+		path      string
+		userAgent string
+		expected  bool
+	}{
+		{"GET", "/api/users", "", false},
+		{"GET", "/api/users", "Mozilla/5.0", true},
+		{"GET", "/users", "", false},
+		{"GET", "/api/internal/metrics", "", false},
+		{"DELETE", "/api/users", "Mozilla/5.0", false},
+		{"POST", "/api/users", "Mozilla/5.0", true},
+		{"POST", "/api/users", "curl/7.54.0", false},
+	} {
+		if got := f.Allow(c.method, c.path, c.userAgent); got != c.expected {
+			t.Errorf("expected Allow(%s, %s, %q) to be %v, but got %v", c.method, c.path, c.userAgent, c.expected, got)
+		}
+	}
+
+	var a AccessProf
+	a.Filter = f
+	a.Record(&AccessLog{Method: "GET", Path: "/api/users", Status: 200})
+	if a.Count() != 1 {
+		t.Errorf("expected Record to ignore IncludeUserAgents, but got %d access logs", a.Count())
+	}
+	if a.Begin(&AccessLog{Method: "GET", Path: "/api/users"}, func(string) string { return "" }) {
+		t.Errorf("expected Begin to reject requests without User-Agent")
+	}
+}
+
+func TestAccessProf_ReportHandler_json(t *testing.T) {
+	var a AccessProf
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200})
+	server := httptest.NewServer(a.ReportHandler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	report, err := LoadReport(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RequestCount() != 1 {
+		t.Fatalf("expected 1 request in the JSON report, but got %d", report.RequestCount())
+	}
+}
+
+func TestAccessProf_ReportHandler_events(t *testing.T) {
+	var a AccessProf
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200})
+	server := httptest.NewServer(a.ReportHandler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?format=events&interval=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected Content-Type text/event-stream, but got %q", ct)
+	}
+	r := bufio.NewReader(res.Body)
+	for _, expected := range []string{"event: report\n", `data: {"request_count":1,`} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, expected) {
+			t.Fatalf("expected a line starting with %q, but got %q", expected, line)
+		}
+	}
+
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200})
+	r.ReadString('\n')
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "event: report\n") {
+		t.Fatalf("expected the next event, but got %q", line)
+	}
+	line, _ = r.ReadString('\n')
+	if !strings.HasPrefix(line, `data: {"request_count":2,`) {
+		t.Fatalf("expected the next event to have 2 requests, but got %q", line)
+	}
+}
+
+func TestAccessProf_liveEvent(t *testing.T) {
+	var a AccessProf
+	a.Record(&AccessLog{Method: "GET", Path: "/<img src=x onerror=alert(1)>", Status: 200})
+
+	body, err := a.liveEvent(nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var live liveReport
+	if err := json.Unmarshal(body, &live); err != nil {
+		t.Fatal(err)
+	}
+	if cell := live.Rows[0][2]; cell != "/&lt;img src=x onerror=alert(1)&gt;" {
+		t.Fatalf("expected cells of the live report to be escaped, but got %q", cell)
+	}
+
+	grouped := AccessProf{GroupByHeaders: []string{"res:Content-Type"}}
+	grouped.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, ResponseHeaders: map[string]string{"Content-Type": "<script>"}})
+	groupedBody, err := grouped.liveEvent(nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live = liveReport{}
+	if err := json.Unmarshal(groupedBody, &live); err != nil {
+		t.Fatal(err)
+	}
+	if cell := live.Rows[0][12]; cell != "res:Content-Type=&lt;script&gt;" {
+		t.Fatalf("expected the headers cell of the live report to be escaped, but got %q", cell)
+	}
+
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200})
+	shared, err := a.liveEvent(nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, shared) {
+		t.Fatal("clients of the live report should share the report built within the interval")
+	}
+}
+
+func TestAccessProf_ServeHTTP_concurrency(t *testing.T) {
+	var a AccessProf
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 2)
+	server := httptest.NewServer(a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}), ""))
+	defer server.Close()
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			http.Get(server.URL)
+			done <- struct{}{}
+		}()
+	}
+	<-arrived
+	<-arrived
+	close(release)
+	<-done
+	<-done
+
+	if c := a.Report(nil).MaxConcurrency(); c != 2 {
+		t.Fatalf("expected max concurrency 2, but got %d", c)
+	}
+}
+
+func TestAccessProf_Begin(t *testing.T) {
+	a := AccessProf{Filter: &Filter{ExcludeUserAgents: []*regexp.Regexp{regexp.MustCompile("bot")}}}
+	header := func(userAgent string) func(string) string {
+		return func(name string) string {
+			if name == "User-Agent" {
+				return userAgent
+			}
+			return ""
+		}
+	}
+
+	if a.Begin(&AccessLog{Method: "GET", Path: "/users"}, header("bot/1.0")) {
+		t.Fatal("requests rejected by Filter should not be begun")
+	}
+	first := &AccessLog{Method: "GET", Path: "/users", Status: 200}
+	second := &AccessLog{Method: "GET", Path: "/users", Status: 200}
+	if !a.Begin(first, header("curl")) || !a.Begin(second, header("")) {
+		t.Fatal("requests allowed by Filter should be begun")
+	}
+	if first.InFlight != 1 || second.InFlight != 2 {
+		t.Fatalf("expected in-flight requests 1 and 2, but got %d and %d", first.InFlight, second.InFlight)
+	}
+	a.Finish(first, nil)
+	a.Finish(second, nil)
+
+	func() {
+		defer func() { recover() }()
+		l := &AccessLog{Method: "GET", Path: "/panic"}
+		if a.Begin(l, header("")) {
+			defer a.Finish(l, nil)
+			panic("handler panicked")
+		}
+	}()
+	third := &AccessLog{Method: "GET", Path: "/users", Status: 200}
+	a.Begin(third, header(""))
+	a.Finish(third, nil)
+	if third.InFlight != 1 {
+		t.Fatalf("finished requests should not be in flight, but got %d requests in flight", third.InFlight)
+	}
+	if a.Count() != 4 {
+		t.Fatalf("expected 4 recorded requests, but got %d", a.Count())
+	}
+}
+
+func TestAccessProf_CaptureHeaders(t *testing.T) {
+	a := AccessProf{
+		LogFile:                "ltsv",
+		CaptureRequestHeaders:  []string{"X-Request-ID"},
+		CaptureResponseHeaders: []string{"content-encoding"},
+		GroupByHeaders:         []string{"res:Content-Encoding"},
+		Filter:                 &Filter{ExcludeHeaders: map[string]*regexp.Regexp{"X-Debug": regexp.MustCompile("^1$")}},
+	}
+	defer os.Remove("ltsv")
+	server := httptest.NewServer(a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("gzip") != "" {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Write([]byte("ok"))
+	}), ""))
+	defer server.Close()
+
+	get := func(query string, header http.Header) {
+		req, _ := http.NewRequest("GET", server.URL+"/users"+query, nil)
+		for name := range header {
+			req.Header.Set(name, header.Get(name))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	get("?gzip=1", http.Header{"X-Request-Id": {"req-1\tinjected"}})
+	get("", http.Header{"X-Request-Id": {"req-2"}})
+	get("", http.Header{"X-Debug": {"1"}})
+
+	// read logs back from LTSV
+	if err := a.flushLogs(); err != nil {
+		t.Fatal(err)
+	}
+	report := a.Report(nil)
+	if len(report.Segments) != 2 {
+		t.Fatalf("expected 2 segments grouped by Content-Encoding, but got %d", len(report.Segments))
+	}
+	seg := report.Segments[0]
+	if s := seg.headersText(); s != "res:Content-Encoding=gzip" {
+		t.Fatalf("unexpected grouping headers %q", s)
+	}
+	l := seg.AccessLogs[0]
+	if l.RequestHeaders["X-Request-Id"] != "req-1\tinjected" || l.ResponseHeaders["Content-Encoding"] != "gzip" {
+		t.Fatalf("headers are not captured: %v %v", l.RequestHeaders, l.ResponseHeaders)
+	}
+	if report.Segments[1].headersText() != "res:Content-Encoding=" {
+		t.Fatalf("unexpected grouping headers %q", report.Segments[1].headersText())
+	}
+}
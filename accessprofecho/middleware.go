@@ -0,0 +1,45 @@
+// Package accessprofecho provides an echo middleware which records requests into accessprof.AccessProf.
+//
+// Requests are aggregated by echo's route templates (e.g. "/users/:id").
+// The report can be mounted with echo.WrapHandler:
+//
+//	e.Use(accessprofecho.Middleware(&prof))
+//	e.Any("/accessprof", echo.WrapHandler(prof.ReportHandler()))
+package accessprofecho
+
+import (
+	"github.com/agatan/accessprof"
+	"github.com/agatan/timejump"
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware returns an echo middleware which records requests into a.
+func Middleware(a *accessprof.AccessProf) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			l := &accessprof.AccessLog{
+				Method:          req.Method,
+				Path:            req.URL.Path,
+				RequestBodySize: req.ContentLength,
+				AccessedAt:      timejump.Now(),
+			}
+			if !a.Begin(l, req.Header.Get) {
+				return next(c)
+			}
+			defer a.Finish(l, c.Response().Header().Get)
+			start := timejump.Now()
+			err := next(c)
+			if err != nil {
+				// Let echo write the error response, so that its status is recorded.
+				// The error is still returned to the outer middlewares, and echo doesn't write the committed response again.
+				c.Error(err)
+			}
+			l.ResponseTime = timejump.Now().Sub(start)
+			l.Route = c.Path()
+			l.Status = c.Response().Status
+			l.ResponseBodySize = int(c.Response().Size)
+			return err
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package accessprofecho
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/agatan/accessprof"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddleware(t *testing.T) {
+	var a accessprof.AccessProf
+	e := echo.New()
+	var errs []error
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			errs = append(errs, err)
+			return err
+		}
+	})
+	e.Use(Middleware(&a))
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, "user "+c.Param("id"))
+	})
+	e.GET("/error", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/error"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if errs[2] == nil {
+		t.Error("the error of the handler should be returned to outer middlewares")
+	}
+
+	report := a.Report(nil)
+	if len(report.Segments) != 2 {
+		t.Fatalf("expected 2 segments, GET /users/:id and GET /error; but got %d", len(report.Segments))
+	}
+	if seg := report.Segments[0]; seg.Path != "/users/:id" || seg.Count() != 2 || seg.MaxBody() != len("user 1") {
+		t.Errorf("unexpected segment: %+v", seg)
+	}
+	if c := report.MaxConcurrency(); c != 1 {
+		t.Errorf("expected max concurrency 1 of sequential requests, but got %d", c)
+	}
+	if seg := report.Segments[1]; seg.Status != http.StatusTeapot {
+		t.Errorf("expected status %d, but got %d", http.StatusTeapot, seg.Status)
+	}
+}
+
+func TestMiddleware_headers(t *testing.T) {
+	a := accessprof.AccessProf{
+		CaptureRequestHeaders:  []string{"X-Request-Id"},
+		CaptureResponseHeaders: []string{"Content-Type"},
+		Filter:                 &accessprof.Filter{ExcludeHeaders: map[string]*regexp.Regexp{"X-Debug": regexp.MustCompile("1")}},
+	}
+	e := echo.New()
+	e.Use(Middleware(&a))
+	e.GET("/users", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, []string{})
+	})
+
+	for _, header := range []string{"X-Request-Id", "X-Debug"} {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set(header, "1")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	report := a.Report(nil)
+	if len(report.Segments) != 1 || report.Segments[0].Count() != 1 {
+		t.Fatalf("expected the request with X-Debug to be excluded, but got %d segments", len(report.Segments))
+	}
+	l := report.Segments[0].AccessLogs[0]
+	if l.RequestHeaders["X-Request-Id"] != "1" || l.ResponseHeaders["Content-Type"] != echo.MIMEApplicationJSON {
+		t.Fatalf("headers are not captured: %v %v", l.RequestHeaders, l.ResponseHeaders)
+	}
+}
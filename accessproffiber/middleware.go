@@ -0,0 +1,48 @@
+// Package accessproffiber provides a fiber middleware which records requests into accessprof.AccessProf.
+//
+// Requests are aggregated by fiber's route templates (e.g. "/users/:id").
+// The report can be mounted with fiber's adaptor package:
+//
+//	app.Use(accessproffiber.Middleware(&prof))
+//	app.All("/accessprof", adaptor.HTTPHandler(prof.ReportHandler()))
+package accessproffiber
+
+import (
+	"github.com/agatan/accessprof"
+	"github.com/agatan/timejump"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Middleware returns a fiber middleware which records requests into a.
+func Middleware(a *accessprof.AccessProf) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		l := &accessprof.AccessLog{
+			// Method and Path point into buffers which fiber reuses after the request, so copy them
+			Method:          utils.CopyString(c.Method()),
+			Path:            utils.CopyString(c.Path()),
+			RequestBodySize: int64(len(c.Body())),
+			AccessedAt:      timejump.Now(),
+		}
+		// headers also point into fiber's buffers
+		requestHeader := func(name string) string { return utils.CopyString(c.Get(name)) }
+		responseHeader := func(name string) string { return utils.CopyString(c.GetRespHeader(name)) }
+		if !a.Begin(l, requestHeader) {
+			return c.Next()
+		}
+		defer a.Finish(l, responseHeader)
+		start := timejump.Now()
+		err := c.Next()
+		if err != nil {
+			// Let fiber write the error response, so that its status is recorded.
+			if herr := c.App().ErrorHandler(c, err); herr != nil {
+				c.Status(fiber.StatusInternalServerError)
+			}
+		}
+		l.ResponseTime = timejump.Now().Sub(start)
+		l.Route = c.Route().Path
+		l.Status = c.Response().StatusCode()
+		l.ResponseBodySize = len(c.Response().Body())
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package accessproffiber
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/agatan/accessprof"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMiddleware(t *testing.T) {
+	var a accessprof.AccessProf
+	app := fiber.New()
+	app.Use(Middleware(&a))
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		return c.SendString("user " + c.Params("id"))
+	})
+	app.Get("/error", func(c *fiber.Ctx) error {
+		return fiber.NewError(http.StatusTeapot)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/error"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	report := a.Report(nil)
+	if len(report.Segments) != 2 {
+		t.Fatalf("expected 2 segments, GET /users/:id and GET /error; but got %d", len(report.Segments))
+	}
+	if seg := report.Segments[0]; seg.Path != "/users/:id" || seg.Count() != 2 || seg.MaxBody() != len("user 1") {
+		t.Errorf("unexpected segment: %+v", seg)
+	}
+	if c := report.MaxConcurrency(); c != 1 {
+		t.Errorf("expected max concurrency 1 of sequential requests, but got %d", c)
+	}
+	if seg := report.Segments[1]; seg.Status != http.StatusTeapot {
+		t.Errorf("expected status %d, but got %d", http.StatusTeapot, seg.Status)
+	}
+}
+
+func TestMiddleware_distinctPaths(t *testing.T) {
+	var a accessprof.AccessProf
+	app := fiber.New()
+	app.Use(Middleware(&a))
+	app.Get("/p/:n/:s", func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	expected := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("/p/%d/abcdefgh", i)
+		expected[path] = true
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	got := map[string]bool{}
+	for _, seg := range a.Report(nil).Segments {
+		for _, l := range seg.AccessLogs {
+			if l.Method != http.MethodGet {
+				t.Errorf("expected method GET, but got %q", l.Method)
+			}
+			got[l.Path] = true
+		}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected paths %v, but got %v", expected, got)
+	}
+}
+
+func TestMiddleware_headers(t *testing.T) {
+	a := accessprof.AccessProf{
+		CaptureRequestHeaders:  []string{"X-Request-Id"},
+		CaptureResponseHeaders: []string{"Content-Type"},
+	}
+	app := fiber.New()
+	app.Use(Middleware(&a))
+	app.Get("/users", func(c *fiber.Ctx) error {
+		return c.JSON([]string{})
+	})
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("X-Request-Id", fmt.Sprintf("req-%d", i))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	logs := a.Report(nil).Segments[0].AccessLogs
+	for i, l := range logs {
+		if expected := fmt.Sprintf("req-%d", i); l.RequestHeaders["X-Request-Id"] != expected {
+			t.Errorf("expected X-Request-Id %s, but got %q", expected, l.RequestHeaders["X-Request-Id"])
+		}
+		if l.ResponseHeaders["Content-Type"] != fiber.MIMEApplicationJSON {
+			t.Errorf("expected Content-Type %s, but got %q", fiber.MIMEApplicationJSON, l.ResponseHeaders["Content-Type"])
+		}
+	}
+}
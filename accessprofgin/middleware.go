@@ -0,0 +1,39 @@
+// Package accessprofgin provides a gin middleware which records requests into accessprof.AccessProf.
+//
+// Requests are aggregated by gin's route templates (e.g. "/users/:id").
+// The report can be mounted with gin.WrapH:
+//
+//	r.Use(accessprofgin.Middleware(&prof))
+//	r.Any("/accessprof", gin.WrapH(prof.ReportHandler()))
+package accessprofgin
+
+import (
+	"github.com/agatan/accessprof"
+	"github.com/agatan/timejump"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware returns a gin middleware which records requests into a.
+func Middleware(a *accessprof.AccessProf) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := &accessprof.AccessLog{
+			Method:          c.Request.Method,
+			Path:            c.Request.URL.Path,
+			RequestBodySize: c.Request.ContentLength,
+			AccessedAt:      timejump.Now(),
+		}
+		if !a.Begin(l, c.Request.Header.Get) {
+			c.Next()
+			return
+		}
+		defer a.Finish(l, c.Writer.Header().Get)
+		start := timejump.Now()
+		c.Next()
+		l.ResponseTime = timejump.Now().Sub(start)
+		l.Route = c.FullPath()
+		l.Status = c.Writer.Status()
+		if size := c.Writer.Size(); size > 0 {
+			l.ResponseBodySize = size
+		}
+	}
+}
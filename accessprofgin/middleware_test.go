@@ -0,0 +1,38 @@
+package accessprofgin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/agatan/accessprof"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var a accessprof.AccessProf
+	r := gin.New()
+	r.Use(Middleware(&a))
+	r.GET("/users/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "user "+c.Param("id"))
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/unknown"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	report := a.Report(nil)
+	if len(report.Segments) != 2 {
+		t.Fatalf("expected 2 segments, GET /users/:id and 404; but got %d", len(report.Segments))
+	}
+	if seg := report.Segments[0]; seg.Path != "/users/:id" || seg.Count() != 2 || seg.MaxBody() != len("user 1") {
+		t.Errorf("unexpected segment: %+v", seg)
+	}
+	if c := report.MaxConcurrency(); c != 1 {
+		t.Errorf("expected max concurrency 1 of sequential requests, but got %d", c)
+	}
+	if seg := report.Segments[1]; seg.Status != http.StatusNotFound {
+		t.Errorf("expected status %d, but got %d", http.StatusNotFound, seg.Status)
+	}
+}
@@ -0,0 +1,128 @@
+// Package accessprofgrpc provides gRPC server interceptors which record RPCs into accessprof.AccessProf.
+//
+// Each RPC is recorded as an access log with method "GRPC", the full method name as the path,
+// the HTTP status equivalent to the gRPC status code as the status (see HTTPStatus), so that failed RPCs
+// count in error rates and alerts like failed HTTP requests, the gRPC status code itself as GRPCCode
+// (e.g. "DataLoss", shown next to the status of 500), and the total size of received and sent messages as body sizes.
+package accessprofgrpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/agatan/accessprof"
+	"github.com/agatan/timejump"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Method is the method of access logs recorded by the interceptors.
+const Method = "GRPC"
+
+// UnaryServerInterceptor returns an interceptor which records unary RPCs into a.
+func UnaryServerInterceptor(a *accessprof.AccessProf) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		l := &accessprof.AccessLog{
+			Method:          Method,
+			Path:            info.FullMethod,
+			RequestBodySize: int64(messageSize(req)),
+			AccessedAt:      timejump.Now(),
+		}
+		start := timejump.Now()
+		resp, err := handler(ctx, req)
+		l.ResponseTime = timejump.Now().Sub(start)
+		code := status.Code(err)
+		l.Status, l.GRPCCode = HTTPStatus(code), code.String()
+		if err == nil {
+			l.ResponseBodySize = messageSize(resp)
+		}
+		a.Record(l)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns an interceptor which records streaming RPCs into a.
+// The response time of a streaming RPC is the lifetime of the stream.
+func StreamServerInterceptor(a *accessprof.AccessProf) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		l := &accessprof.AccessLog{
+			Method:     Method,
+			Path:       info.FullMethod,
+			AccessedAt: timejump.Now(),
+		}
+		start := timejump.Now()
+		wrapped := &serverStream{ServerStream: ss}
+		err := handler(srv, wrapped)
+		l.ResponseTime = timejump.Now().Sub(start)
+		code := status.Code(err)
+		l.Status, l.GRPCCode = HTTPStatus(code), code.String()
+		l.RequestBodySize = int64(wrapped.received)
+		l.ResponseBodySize = wrapped.sent
+		a.Record(l)
+		return err
+	}
+}
+
+// HTTPStatus returns the HTTP status equivalent to the gRPC status code c,
+// following the mapping of grpc-gateway (e.g. 200 for OK, 404 for NotFound and 503 for Unavailable).
+func HTTPStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		// Unknown, Internal, DataLoss and undefined codes
+		return http.StatusInternalServerError
+	}
+}
+
+// serverStream counts the size of messages received and sent.
+type serverStream struct {
+	grpc.ServerStream
+	received int
+	sent     int
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received += messageSize(m)
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent += messageSize(m)
+	}
+	return err
+}
+
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
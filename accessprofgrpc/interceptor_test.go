@@ -0,0 +1,91 @@
+package accessprofgrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/agatan/accessprof"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestInterceptors(t *testing.T) {
+	var a accessprof.AccessProf
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(&a)),
+		grpc.StreamInterceptor(StreamServerInterceptor(&a)),
+	)
+	hs := health.NewServer()
+	hs.SetServingStatus("accessprof", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "accessprof"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, but got %v", err)
+	}
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{Service: "accessprof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	server.GracefulStop()
+
+	report := a.Report(nil)
+	if len(report.Segments) != 3 {
+		t.Fatalf("expected 3 segments, OK and NotFound of Check and Watch; but got %d", len(report.Segments))
+	}
+	seg := report.Segments[0]
+	if seg.Method != Method || seg.Path != "/grpc.health.v1.Health/Check" || seg.Status != 200 || seg.GRPCCode != "OK" {
+		t.Errorf("unexpected segment: %+v", seg)
+	}
+	if seg.MaxBody() == 0 {
+		t.Errorf("response message size should be recorded")
+	}
+	if seg := report.Segments[1]; seg.Status != 404 || seg.GRPCCode != "NotFound" {
+		t.Errorf("expected status 404 and code NotFound, but got %d %s", seg.Status, seg.GRPCCode)
+	}
+	if seg := report.Segments[2]; seg.Path != "/grpc.health.v1.Health/Watch" || seg.SumBody() == 0 {
+		t.Errorf("unexpected segment: %+v", seg)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for _, c := range []struct {
+		code     codes.Code
+		expected int
+	}{
+		{codes.OK, 200},
+		{codes.InvalidArgument, 400},
+		{codes.Unavailable, 503},
+		{codes.Internal, 500},
+		{codes.Code(100), 500},
+	} {
+		if got := HTTPStatus(c.code); got != c.expected {
+			t.Errorf("expected %d for %v, but got %d", c.expected, c.code, got)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package accessprof
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agatan/timejump"
+)
+
+// DefaultAlertWindow is the sliding window of alert rules without Window.
+const DefaultAlertWindow = time.Minute
+
+// DefaultAlertInterval is the interval of evaluating alert rules if AccessProf.AlertInterval is 0.
+const DefaultAlertInterval = time.Second
+
+// AlertRule is a condition on recent inbound requests evaluated every AccessProf.AlertInterval.
+// The rule trips if either of the latency or the error rate exceeds its limit.
+type AlertRule struct {
+	// Name identifies the rule in alerts
+	Name string
+	// Method is the HTTP method of target requests (all methods if empty)
+	Method string
+	// Path is matched against the path or the route of target requests (all paths if nil)
+	Path *regexp.Regexp
+	// Window is the sliding window of target requests (DefaultAlertWindow if 0)
+	Window time.Duration
+	// MinCount is the minimum number of requests in the window to evaluate the rule (1 if 0).
+	// A firing rule resolves when the window has fewer requests.
+	MinCount int
+	// Percentile is the percentile of response times compared with MaxLatency (99 if 0)
+	Percentile float64
+	// MaxLatency limits the percentile of response times (ignored if 0)
+	MaxLatency time.Duration
+	// MaxErrorRate limits the ratio of 5xx responses (ignored if 0)
+	MaxErrorRate float64
+}
+
+// Alert notifies that a rule has tripped or resolved.
+type Alert struct {
+	Rule *AlertRule `json:"-"`
+	Name string     `json:"name"`
+	// Firing is true when the rule trips, and false when it resolves
+	Firing  bool      `json:"firing"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+type alertEntry struct {
+	at           time.Time
+	responseTime time.Duration
+	isError      bool
+}
+
+// alertState is the sliding window and the firing state of a rule.
+// Each rule has its own lock, so that requests of different rules don't wait for each other.
+type alertState struct {
+	mu      sync.Mutex
+	entries []alertEntry
+	latency latencySketch
+	errs    int
+	firing  bool
+}
+
+func (r *AlertRule) match(l *AccessLog) bool {
+	if l.Outbound || r.Method != "" && r.Method != l.Method {
+		return false
+	}
+	return r.Path == nil || r.Path.MatchString(l.Path) || l.Route != "" && r.Path.MatchString(l.Route)
+}
+
+func (r *AlertRule) window() time.Duration {
+	if r.Window <= 0 {
+		return DefaultAlertWindow
+	}
+	return r.Window
+}
+
+// observe adds l to the window, and drops requests older than the window.
+// The rule is not evaluated here, since the percentile is too expensive to compute for every request.
+func (s *alertState) observe(r *AlertRule, l *AccessLog, now time.Time) {
+	s.entries = append(s.entries, alertEntry{at: now, responseTime: l.ResponseTime, isError: l.Status >= 500})
+	s.latency.add(l.ResponseTime)
+	if l.Status >= 500 {
+		s.errs++
+	}
+	s.expire(r, now)
+}
+
+// expire drops requests older than the window at now.
+func (s *alertState) expire(r *AlertRule, now time.Time) {
+	drop := 0
+	since := now.Add(-r.window())
+	for drop < len(s.entries) && !s.entries[drop].at.After(since) {
+		e := s.entries[drop]
+		s.latency.remove(e.responseTime)
+		if e.isError {
+			s.errs--
+		}
+		drop++
+	}
+	s.entries = s.entries[drop:]
+}
+
+// evaluate evaluates r over the window at now, and returns the alert if the state changes.
+// The state is not changed until the alert is delivered (see evaluateAlerts).
+// A firing rule resolves if the window has fewer requests than MinCount, e.g. after traffic stops.
+func (s *alertState) evaluate(r *AlertRule, now time.Time) *Alert {
+	s.expire(r, now)
+	minCount := r.MinCount
+	if minCount <= 0 {
+		minCount = 1
+	}
+	var tripped bool
+	var message string
+	if len(s.entries) < minCount {
+		if !s.firing {
+			return nil
+		}
+		message = fmt.Sprintf("%s: %d requests over %s (min %d)", r.Name, len(s.entries), r.window(), minCount)
+	} else {
+		tripped, message = s.check(r)
+	}
+	if tripped == s.firing {
+		return nil
+	}
+	if !tripped {
+		message = "resolved: " + message
+	}
+	return &Alert{Rule: r, Name: r.Name, Firing: tripped, Message: message, At: now}
+}
+
+func (s *alertState) check(r *AlertRule) (bool, string) {
+	percentile := r.Percentile
+	if percentile == 0 {
+		percentile = 99
+	}
+	latency := s.latency.quantile(percentile / 100)
+	errorRate := float64(s.errs) / float64(len(s.entries))
+	tripped := r.MaxLatency > 0 && latency > r.MaxLatency || r.MaxErrorRate > 0 && errorRate > r.MaxErrorRate
+	message := fmt.Sprintf("%s: p%v %s (max %s), error rate %s (max %s) over %s (%d requests)",
+		r.Name, percentile, latency, r.MaxLatency, formatPercent(errorRate), formatPercent(r.MaxErrorRate), r.window(), len(s.entries))
+	return tripped, message
+}
+
+// observeAlerts adds l to the windows of AlertRules matching it.
+func (a *AccessProf) observeAlerts(l *AccessLog) {
+	if len(a.AlertRules) == 0 || a.OnAlert == nil {
+		return
+	}
+	a.alertOnce.Do(a.startAlerts)
+	now := l.AccessedAt.Add(l.ResponseTime)
+	if l.AccessedAt.IsZero() {
+		now = timejump.Now()
+	}
+	for _, rule := range a.AlertRules {
+		s, ok := a.alertStates[rule]
+		if !ok || !rule.match(l) {
+			continue
+		}
+		s.mu.Lock()
+		s.observe(rule, l, now)
+		s.mu.Unlock()
+	}
+}
+
+// startAlerts evaluates AlertRules every AlertInterval until Close is called.
+// Rules added to AlertRules after the first request are ignored.
+func (a *AccessProf) startAlerts() {
+	a.alertStates = make(map[*AlertRule]*alertState, len(a.AlertRules))
+	for _, rule := range a.AlertRules {
+		a.alertStates[rule] = new(alertState)
+	}
+	alerts := make(chan *Alert, 64)
+	stop := make(chan struct{})
+	a.mu.Lock()
+	a.stopAlerts = stop
+	a.mu.Unlock()
+	go dispatchAlerts(a.OnAlert, alerts)
+	go func() {
+		defer close(alerts)
+		interval := a.AlertInterval
+		if interval <= 0 {
+			interval = DefaultAlertInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				a.evaluateAlerts(timejump.Now(), alerts)
+			}
+		}
+	}()
+}
+
+// evaluateAlerts evaluates AlertRules at now, and sends alerts of changed states to alerts.
+// Alerts are dropped (and counted by DroppedAlerts) rather than waiting for a slow OnAlert,
+// and the state of the rule is kept so that the alert is sent again at the next evaluation.
+func (a *AccessProf) evaluateAlerts(now time.Time, alerts chan<- *Alert) {
+	for _, rule := range a.AlertRules {
+		s, ok := a.alertStates[rule]
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		if alert := s.evaluate(rule, now); alert != nil {
+			select {
+			case alerts <- alert:
+				s.firing = alert.Firing
+			default:
+				atomic.AddInt64(&a.droppedAlerts, 1)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// DroppedAlerts returns the number of alerts dropped because OnAlert could not keep up with them.
+func (a *AccessProf) DroppedAlerts() int64 {
+	return atomic.LoadInt64(&a.droppedAlerts)
+}
+
+// dispatchAlerts calls onAlert in order, so that a slow callback does not block evaluating rules.
+func dispatchAlerts(onAlert func(*Alert), alerts <-chan *Alert) {
+	for alert := range alerts {
+		onAlert(alert)
+	}
+}
+
+// WebhookAlert returns an AccessProf.OnAlert callback which posts alerts to url as JSON.
+// Failed posts are ignored.
+func WebhookAlert(url string) func(*Alert) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(alert *Alert) {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			return
+		}
+		res, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		res.Body.Close()
+	}
+}
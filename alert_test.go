@@ -0,0 +1,116 @@
+package accessprof
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAccessProf_AlertRules(t *testing.T) {
+	alerts := make(chan *Alert, 10)
+	rule := &AlertRule{Name: "slow users", Path: regexp.MustCompile(`^/users/\d+$`), MaxLatency: 100 * time.Millisecond, Window: 200 * time.Millisecond}
+	a := &AccessProf{AlertRules: []*AlertRule{rule}, OnAlert: func(alert *Alert) { alerts <- alert }, AlertInterval: 10 * time.Millisecond}
+	defer a.Close()
+
+	record := func(path string, d time.Duration) {
+		a.Record(&AccessLog{Method: "GET", Path: path, Status: 200, ResponseTime: d, AccessedAt: time.Now().Add(-d)})
+	}
+
+	record("/items", time.Second)
+	record("/users/1", time.Second)
+	record("/users/2", time.Second)
+	alert := <-alerts
+	if !alert.Firing || alert.Rule != rule {
+		t.Fatalf("expected the rule to fire, but got %+v", alert)
+	}
+
+	for i := 0; i < 200; i++ {
+		record("/users/1", time.Millisecond)
+	}
+	alert = <-alerts
+	if alert.Firing {
+		t.Fatalf("expected the rule to resolve, but got %+v", alert)
+	}
+	select {
+	case alert := <-alerts:
+		t.Fatalf("alerts should fire once, but got %+v", alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the rule resolves once the slow requests drop out of the window without traffic
+	record("/users/1", time.Second)
+	if alert := <-alerts; !alert.Firing {
+		t.Fatalf("expected the rule to fire, but got %+v", alert)
+	}
+	if alert := <-alerts; alert.Firing {
+		t.Fatalf("expected the rule to resolve after traffic stops, but got %+v", alert)
+	}
+}
+
+func TestAccessProf_DroppedAlerts(t *testing.T) {
+	rule := &AlertRule{MaxErrorRate: 0.5}
+	a := &AccessProf{AlertRules: []*AlertRule{rule}, OnAlert: func(*Alert) {}, AlertInterval: time.Hour}
+	defer a.Close()
+	now := time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC)
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 500, AccessedAt: now})
+
+	// nobody receives alerts
+	a.evaluateAlerts(now, make(chan *Alert))
+	if n := a.DroppedAlerts(); n != 1 {
+		t.Fatalf("expected 1 dropped alert, but got %d", n)
+	}
+
+	// the dropped alert is sent at the next evaluation
+	alerts := make(chan *Alert, 1)
+	a.evaluateAlerts(now, alerts)
+	if alert := <-alerts; !alert.Firing {
+		t.Fatalf("expected the dropped alert to fire, but got %+v", alert)
+	}
+	a.evaluateAlerts(now, alerts)
+	select {
+	case alert := <-alerts:
+		t.Fatalf("alerts should fire once delivered, but got %+v", alert)
+	default:
+	}
+}
+
+func TestAlertState_window(t *testing.T) {
+	rule := &AlertRule{MaxErrorRate: 0.5, Window: time.Minute, MinCount: 2}
+	var s alertState
+	now := time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC)
+	s.observe(rule, &AccessLog{Status: 500}, now)
+	if alert := s.evaluate(rule, now); alert != nil {
+		t.Fatalf("rules should not be evaluated with fewer requests than MinCount, but got %+v", alert)
+	}
+	s.observe(rule, &AccessLog{Status: 500}, now)
+	if alert := s.evaluate(rule, now); alert == nil || !alert.Firing {
+		t.Fatalf("expected the rule to fire, but got %+v", alert)
+	}
+	s.firing = true
+	// the errors drop out of the window
+	s.observe(rule, &AccessLog{Status: 200}, now.Add(2*time.Minute))
+	if len(s.entries) != 1 || s.errs != 0 || s.latency.count != 1 {
+		t.Fatalf("old requests should be dropped from the window, but got %d entries", len(s.entries))
+	}
+	if alert := s.evaluate(rule, now.Add(2*time.Minute)); alert == nil || alert.Firing {
+		t.Fatalf("expected the rule to resolve below MinCount, but got %+v", alert)
+	}
+}
+
+func TestWebhookAlert(t *testing.T) {
+	received := make(chan *Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		json.NewDecoder(r.Body).Decode(&alert)
+		received <- &alert
+	}))
+	defer server.Close()
+
+	WebhookAlert(server.URL)(&Alert{Name: "errors", Firing: true, Message: "error rate 10.00%"})
+	if alert := <-received; alert.Name != "errors" || !alert.Firing {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+}
@@ -0,0 +1,83 @@
+package accessprof
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// BasicAuthChallenge is the AccessProf.AuthChallenge which lets browsers prompt for BasicAuth credentials.
+const BasicAuthChallenge = `Basic realm="accessprof"`
+
+// BasicAuth returns an authorizer which accepts requests with the given basic auth credentials.
+// It can be used as AccessProf.Authorize or AccessProf.AuthorizeReset (see also BasicAuthChallenge).
+func BasicAuth(user, password string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		u, p, ok := r.BasicAuth()
+		return ok && secureEqual(u, user) && secureEqual(p, password)
+	}
+}
+
+// BearerToken returns an authorizer which accepts requests with the header "Authorization: Bearer <token>".
+// It can be used as AccessProf.Authorize or AccessProf.AuthorizeReset.
+func BearerToken(token string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		auth := r.Header.Get("Authorization")
+		const prefix = "Bearer "
+		return strings.HasPrefix(auth, prefix) && secureEqual(auth[len(prefix):], token)
+	}
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// CSRFTokenHeader is the header which carries the CSRF token in requests to reset logs and cut snapshots.
+const CSRFTokenHeader = "X-CSRF-Token"
+
+// CSRFToken returns the token required in the CSRFTokenHeader of requests to reset logs and cut snapshots
+// when Authorize or AuthorizeReset is set. The HTML report sends it automatically.
+// Requests with a bearer token and no cookies (e.g. scripts using BearerToken) don't need it,
+// since browsers never send such credentials by themselves.
+func (a *AccessProf) CSRFToken() string {
+	a.csrfOnce.Do(func() {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		a.csrfToken = hex.EncodeToString(b)
+	})
+	return a.csrfToken
+}
+
+// authorize checks whether r may access the report. reset is true for destructive requests.
+// It writes an error response and returns false if r is not allowed.
+func (a *AccessProf) authorize(w http.ResponseWriter, r *http.Request, reset bool) bool {
+	if a.Authorize != nil && !a.Authorize(r) {
+		if a.AuthChallenge != "" {
+			w.Header().Set("WWW-Authenticate", a.AuthChallenge)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	if reset && a.AuthorizeReset != nil && !a.AuthorizeReset(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	if r.Method != http.MethodGet && (a.Authorize != nil || a.AuthorizeReset != nil) && !bearerOnly(r) {
+		if !secureEqual(r.Header.Get(CSRFTokenHeader), a.CSRFToken()) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("invalid CSRF token"))
+			return false
+		}
+	}
+	return true
+}
+
+// bearerOnly reports whether r carries a bearer token and no credentials which browsers send automatically
+// (cookies and basic auth), so that it cannot be forged by other sites.
+func bearerOnly(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") && r.Header.Get("Cookie") == ""
+}
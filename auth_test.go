@@ -0,0 +1,82 @@
+package accessprof
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessProf_ReportHandler_authorize(t *testing.T) {
+	basic, bearer := BasicAuth("user", "pass"), BearerToken("admin-token")
+	a := AccessProf{
+		Authorize:      func(r *http.Request) bool { return basic(r) || bearer(r) },
+		AuthorizeReset: bearer,
+	}
+	server := httptest.NewServer(a.ReportHandler())
+	defer server.Close()
+
+	for _, c := range []struct {
+		name     string
+		method   string
+		user     string
+		token    string
+		csrf     string
+		expected int
+	}{
+		{"no credentials", http.MethodGet, "", "", "", http.StatusUnauthorized},
+		{"basic auth", http.MethodGet, "user", "", "", http.StatusOK},
+		{"snapshot without CSRF token", http.MethodPost, "user", "", "", http.StatusForbidden},
+		{"snapshot", http.MethodPost, "user", "", a.CSRFToken(), http.StatusCreated},
+		{"reset without permission", http.MethodDelete, "user", "", a.CSRFToken(), http.StatusForbidden},
+		{"reset with a bearer token and a CSRF token", http.MethodDelete, "", "admin-token", a.CSRFToken(), http.StatusOK},
+		{"reset by a script with a bearer token", http.MethodDelete, "", "admin-token", "", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(c.method, server.URL, nil)
+		if c.user != "" {
+			req.SetBasicAuth(c.user, "pass")
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if c.csrf != "" {
+			req.Header.Set(CSRFTokenHeader, c.csrf)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.expected {
+			t.Errorf("%s: expected status %d, but got %d", c.name, c.expected, resp.StatusCode)
+		}
+	}
+}
+
+func TestAccessProf_ReportHandler_AuthChallenge(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		a        *AccessProf
+		expected string
+	}{
+		{"bearer token", &AccessProf{Authorize: BearerToken("token")}, ""},
+		{"basic auth", &AccessProf{Authorize: BasicAuth("user", "pass"), AuthChallenge: BasicAuthChallenge}, BasicAuthChallenge},
+	} {
+		w := httptest.NewRecorder()
+		c.a.ReportHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != c.expected {
+			t.Errorf("%s: expected status 401 with challenge %q, but got %d with %q", c.name, c.expected, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestAccessProf_ReportHandler_bearerWithCookie(t *testing.T) {
+	a := AccessProf{Authorize: BearerToken("token")}
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Cookie", "session=1")
+	w := httptest.NewRecorder()
+	a.ReportHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("requests with cookies should need the CSRF token, but got status %d", w.Code)
+	}
+}
@@ -0,0 +1,211 @@
+package accessprof
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rule is a threshold checked by Check against the segments whose method and aggregation path match.
+type Rule struct {
+	// Method is the HTTP method of target segments (all methods if empty)
+	Method string `json:"method,omitempty" yaml:"method,omitempty"`
+	// Path is the aggregation path of target segments, or a regexp matched against the whole aggregation path
+	// (all paths if empty)
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// MaxIncrease limits the relative increase of metrics from the baseline (e.g. {"p95": 0.1} for +10%).
+	// Available metrics are the numeric column keys (count, rps, max_concurrency, avg_concurrency, min, max, sum, avg,
+	// p50, p90, p95, p99, min_body, max_body, sum_body and avg_body).
+	MaxIncrease map[string]float64 `json:"max_increase,omitempty" yaml:"max_increase,omitempty"`
+	// MaxDecrease limits the relative decrease of metrics from the baseline (e.g. {"apdex": 0.05} for -5%).
+	// In addition to the metrics of MaxIncrease, it accepts apdex, within_target and error_budget,
+	// for which a decrease is a regression.
+	MaxDecrease map[string]float64 `json:"max_decrease,omitempty" yaml:"max_decrease,omitempty"`
+	// MaxErrorRate limits the ratio of 5xx responses among all responses of the method and path (ignored if nil)
+	MaxErrorRate *float64 `json:"max_error_rate,omitempty" yaml:"max_error_rate,omitempty"`
+
+	pathRegexp *regexp.Regexp
+}
+
+// Rules is a set of rules, usually loaded from a rules file by ParseRules.
+type Rules struct {
+	Rules []*Rule `json:"rules" yaml:"rules"`
+}
+
+// ParseRules reads rules written in JSON.
+func ParseRules(r io.Reader) (*Rules, error) {
+	var rules Rules
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, errors.Wrap(err, "failed to parse rules")
+	}
+	return &rules, nil
+}
+
+func (rule *Rule) compile() error {
+	for metric := range rule.MaxIncrease {
+		c, err := lookupMetric(metric)
+		if err != nil {
+			return err
+		}
+		if c.higherIsBetter {
+			return errors.Errorf("an increase of metric %q is an improvement; use max_decrease", metric)
+		}
+	}
+	for metric := range rule.MaxDecrease {
+		if _, err := lookupMetric(metric); err != nil {
+			return err
+		}
+	}
+	if rule.Path != "" {
+		re, err := regexp.Compile("^(?:" + rule.Path + ")$")
+		if err != nil {
+			return errors.Wrapf(err, "failed to compile regexp %q", rule.Path)
+		}
+		rule.pathRegexp = re
+	}
+	return nil
+}
+
+// lookupMetric returns the numeric column of metric.
+func lookupMetric(metric string) (*column, error) {
+	c, err := lookupColumn(metric)
+	if err != nil {
+		return nil, errors.Errorf("unknown metric %q", metric)
+	}
+	if c.value == nil {
+		return nil, errors.Errorf("metric %q is not numeric", metric)
+	}
+	return c, nil
+}
+
+func (rule *Rule) match(method, path string) bool {
+	if rule.Method != "" && rule.Method != method {
+		return false
+	}
+	return rule.pathRegexp == nil || rule.Path == path || rule.pathRegexp.MatchString(path)
+}
+
+// Violation is a rule violated by the current report.
+type Violation struct {
+	Rule   *Rule
+	Method string
+	Path   string
+	// Host is the destination host of outbound segments
+	Host string
+	// Status is the status code of the violating segment (0 for error rate violations)
+	Status int
+	Metric string
+	// Baseline is the metric value of the baseline report (0 for error rate violations)
+	Baseline float64
+	Current  float64
+	// Limit is the maximum relative increase (or decrease), or the maximum error rate
+	Limit float64
+	// Decrease is true for violations of Rule.MaxDecrease
+	Decrease bool
+}
+
+func (v *Violation) String() string {
+	if v.Metric == "error_rate" {
+		return fmt.Sprintf("%s %s%s: error rate %.2f%% exceeds %.2f%%", v.Method, v.Host, v.Path, v.Current*100, v.Limit*100)
+	}
+	if v.Decrease {
+		return fmt.Sprintf("%d %s %s%s: %s decreased by %.2f%% (%s -> %s), exceeds %.2f%%",
+			v.Status, v.Method, v.Host, v.Path, v.Metric,
+			-relativeIncrease(v.Baseline, v.Current)*100, formatMetric(v.Metric, v.Baseline), formatMetric(v.Metric, v.Current),
+			v.Limit*100)
+	}
+	return fmt.Sprintf("%d %s %s%s: %s increased by %.2f%% (%s -> %s), exceeds %.2f%%",
+		v.Status, v.Method, v.Host, v.Path, v.Metric,
+		relativeIncrease(v.Baseline, v.Current)*100, formatMetric(v.Metric, v.Baseline), formatMetric(v.Metric, v.Current),
+		v.Limit*100)
+}
+
+func formatMetric(metric string, v float64) string {
+	if c, err := lookupColumn(metric); err == nil && c.duration {
+		return stringifyDuration(time.Duration(v))
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// relativeIncrease returns the change from base relative to its magnitude,
+// so that a drop of a negative value (e.g. an exhausted error budget) is negative too.
+func relativeIncrease(base, current float64) float64 {
+	if base == 0 {
+		switch {
+		case current > 0:
+			return 1
+		case current < 0:
+			return -1
+		}
+		return 0
+	}
+	return (current - base) / math.Abs(base)
+}
+
+// Check compares the current report against the baseline and returns all violations of rules.
+// Segments which are missing in the baseline are checked only for error rates.
+func Check(baseline, current *Report, rules *Rules) ([]*Violation, error) {
+	for _, rule := range rules.Rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	var violations []*Violation
+	for _, row := range CompareReports(baseline, current).Rows {
+		if row.Base == nil || row.Target == nil {
+			continue
+		}
+		for _, rule := range rules.Rules {
+			if !rule.match(row.Method, row.Path) {
+				continue
+			}
+			for _, limits := range []struct {
+				max      map[string]float64
+				decrease bool
+			}{{rule.MaxIncrease, false}, {rule.MaxDecrease, true}} {
+				metrics := make([]string, 0, len(limits.max))
+				for metric := range limits.max {
+					metrics = append(metrics, metric)
+				}
+				sort.Strings(metrics)
+				for _, metric := range metrics {
+					c, _ := lookupColumn(metric)
+					base, cur := c.value(row.Base), c.value(row.Target)
+					change := relativeIncrease(base, cur)
+					if limits.decrease {
+						change = -change
+					}
+					if change > limits.max[metric] {
+						violations = append(violations, &Violation{
+							Rule: rule, Method: row.Method, Path: row.Path, Host: row.Host, Status: row.Status,
+							Metric: metric, Baseline: base, Current: cur, Limit: limits.max[metric], Decrease: limits.decrease,
+						})
+					}
+				}
+			}
+		}
+	}
+
+	for _, summary := range current.StatusSummaries() {
+		for _, rule := range rules.Rules {
+			if rule.MaxErrorRate == nil || !rule.match(summary.Method, summary.Path) {
+				continue
+			}
+			if rate := summary.ErrorRate(); rate > *rule.MaxErrorRate {
+				violations = append(violations, &Violation{
+					Rule: rule, Method: summary.Method, Path: summary.Path, Host: summary.Host,
+					Metric: "error_rate", Current: rate, Limit: *rule.MaxErrorRate,
+				})
+			}
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,82 @@
+package accessprof
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeLogs(method, path string, status int, responseTimes ...time.Duration) []*AccessLog {
+	var logs []*AccessLog
+	for _, d := range responseTimes {
+		logs = append(logs, &AccessLog{Method: method, Path: path, Status: status, ResponseTime: d})
+	}
+	return logs
+}
+
+func TestCheck(t *testing.T) {
+	base := buildReport(append(
+		makeLogs("GET", "/users", 200, 10*time.Millisecond, 10*time.Millisecond),
+		makeLogs("GET", "/items", 200, 10*time.Millisecond)...,
+	), nil, reportOptions{})
+	current := buildReport(append(append(
+		makeLogs("GET", "/users", 200, 20*time.Millisecond, 20*time.Millisecond),
+		makeLogs("GET", "/items", 200, 10*time.Millisecond)...),
+		makeLogs("GET", "/items", 500, 10*time.Millisecond)...,
+	), nil, reportOptions{})
+
+	rules, err := ParseRules(strings.NewReader(`{"rules": [
+		{"max_increase": {"p95": 0.1}},
+		{"method": "GET", "path": "/item.*", "max_error_rate": 0.01}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err := Check(base, current, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, p95 of GET /users and error rate of GET /items; but got %v", violations)
+	}
+	if v := violations[0]; v.Path != "/users" || v.Metric != "p95" {
+		t.Errorf("unexpected violation: %v", v)
+	}
+	if v := violations[1]; v.Path != "/items" || v.Metric != "error_rate" || v.Current != 0.5 {
+		t.Errorf("unexpected violation: %v", v)
+	}
+}
+
+func TestCheck_MaxDecrease(t *testing.T) {
+	slo := &SLO{Target: 100 * time.Millisecond}
+	base := buildReport(makeLogs("GET", "/users", 200, 10*time.Millisecond, 10*time.Millisecond), nil, reportOptions{slo: slo})
+	current := buildReport(makeLogs("GET", "/users", 200, 10*time.Millisecond, time.Second), nil, reportOptions{slo: slo})
+
+	rules := &Rules{Rules: []*Rule{{MaxDecrease: map[string]float64{"apdex": 0.05}}}}
+	violations, err := Check(base, current, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Metric != "apdex" || !violations[0].Decrease {
+		t.Fatalf("expected the violation of apdex decreased by 50%%, but got %v", violations)
+	}
+	if s := violations[0].String(); !strings.Contains(s, "apdex decreased by 50.00%") {
+		t.Errorf("unexpected violation message %q", s)
+	}
+	if violations, _ := Check(current, base, rules); len(violations) != 0 {
+		t.Fatalf("an increase of apdex is an improvement, but got %v", violations)
+	}
+
+	rules = &Rules{Rules: []*Rule{{MaxIncrease: map[string]float64{"apdex": 0.05}}}}
+	if _, err := Check(base, current, rules); err == nil {
+		t.Fatal("Check should reject max_increase of apdex")
+	}
+}
+
+func TestCheck_unknownMetric(t *testing.T) {
+	report := buildReport(nil, nil, reportOptions{})
+	rules := &Rules{Rules: []*Rule{{MaxIncrease: map[string]float64{"p42": 0.1}}}}
+	if _, err := Check(report, report, rules); err == nil {
+		t.Fatal("Check should reject unknown metrics")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/agatan/accessprof"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	baseline := fs.String("baseline", "", "path to the baseline report")
+	rulesFile := fs.String("rules", "", "path to the rules file (JSON or YAML)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: accessprof check -baseline base.json -rules rules.yaml current.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *baseline == "" || *rulesFile == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	base, err := loadReportFile(*baseline)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	current, err := loadReportFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	rules, err := loadRulesFile(*rulesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	violations, err := accessprof.Check(base, current, rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, v := range violations {
+		fmt.Println(v)
+	}
+	if len(violations) != 0 {
+		fmt.Fprintf(os.Stderr, "%d violations\n", len(violations))
+		return 1
+	}
+	return 0
+}
+
+func loadReportFile(path string) (*accessprof.Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open report")
+	}
+	defer f.Close()
+	return accessprof.LoadReport(f)
+}
+
+func loadRulesFile(path string) (*accessprof.Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read rules")
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		var rules accessprof.Rules
+		if err := yaml.Unmarshal(data, &rules); err != nil {
+			return nil, errors.Wrap(err, "failed to parse rules")
+		}
+		return &rules, nil
+	default:
+		return accessprof.ParseRules(bytes.NewReader(data))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/agatan/accessprof"
+)
+
+// source is a report of an instance, fetched from its report endpoint or read from a file.
+// load takes the agg parameter of the merged report; saved reports are not aggregated again.
+type source struct {
+	name string
+	load func(agg string) (*accessprof.Report, error)
+}
+
+func runCollect(args []string) int {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8081", "address to serve the merged report on")
+	dir := fs.String("dir", "", "directory of saved reports (*.json) to merge, named by their file names")
+	token := fs.String("token", "", "bearer token for the report endpoints (use user:password@host in the URLs for basic auth)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: accessprof collect [flags] [http://host/accessprof...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var sources []*source
+	for _, arg := range fs.Args() {
+		if _, err := reportURL(arg, ""); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		// name instances by host, or by the whole URL if hosts are not unique
+		parsed, _ := url.Parse(arg)
+		name := parsed.Host
+		for _, s := range sources {
+			if s.name == name {
+				name = arg
+			}
+		}
+		sources = append(sources, endpointSource(name, arg, *token))
+	}
+	if *dir != "" {
+		files, err := filepath.Glob(filepath.Join(*dir, "*.json"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, file := range files {
+			file := file
+			sources = append(sources, &source{
+				name: strings.TrimSuffix(filepath.Base(file), ".json"),
+				load: func(string) (*accessprof.Report, error) { return loadReportFile(file) },
+			})
+		}
+	}
+	if len(sources) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	log.Printf("serving the merged report of %d instances on http://%s/", len(sources), *listen)
+	if err := http.ListenAndServe(*listen, collectHandler(sources)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// endpointSource returns a source fetching the report from the report endpoint base of an instance.
+func endpointSource(name, base, token string) *source {
+	return &source{
+		name: name,
+		load: func(agg string) (*accessprof.Report, error) {
+			u, err := reportURL(base, agg)
+			if err != nil {
+				return nil, err
+			}
+			return fetchReport(u, token)
+		},
+	}
+}
+
+// collectHandler loads all sources on each request, and serves the merged report
+// as HTML, or as JSON with ?format=json. The agg parameter is forwarded to the report endpoints of instances.
+// Instances which fail to load are logged and skipped.
+func collectHandler(sources []*source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agg := strings.Join(r.URL.Query()["agg"], ",")
+		reports := map[string]*accessprof.Report{}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, s := range sources {
+			wg.Add(1)
+			go func(s *source) {
+				defer wg.Done()
+				report, err := s.load(agg)
+				if err != nil {
+					log.Printf("%s: %v", s.name, err)
+					return
+				}
+				mu.Lock()
+				reports[s.name] = report
+				mu.Unlock()
+			}(s)
+		}
+		wg.Wait()
+		if len(reports) == 0 {
+			http.Error(w, "failed to load reports of all instances", http.StatusBadGateway)
+			return
+		}
+
+		merged := accessprof.MergeInstances(reports)
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			merged.Save(w)
+			return
+		}
+		if err := merged.RenderHTMLWithOptions(w, &accessprof.HTMLOptions{AggregationOnly: true}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/agatan/accessprof"
+)
+
+func TestCollectHandler_aggregates(t *testing.T) {
+	var sources []*source
+	for _, name := range []string{"app1", "app2"} {
+		var a accessprof.AccessProf
+		a.Record(&accessprof.AccessLog{Method: "GET", Path: "/users/1", Status: 200})
+		a.Record(&accessprof.AccessLog{Method: "GET", Path: "/users/2", Status: 200})
+		server := httptest.NewServer(a.ReportHandler())
+		defer server.Close()
+		sources = append(sources, endpointSource(name, server.URL, ""))
+	}
+	server := httptest.NewServer(collectHandler(sources))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?agg=" + url.QueryEscape(`/users/\d+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(body)
+	if !strings.Contains(page, "app1=2 app2=2") {
+		t.Errorf("expected the aggregated segment to have 2 requests of each instance:\n%s", page)
+	}
+	if !strings.Contains(page, `name="agg"`) {
+		t.Error("the merged report should have the aggregation box")
+	}
+	if strings.Contains(page, `id="reset-button"`) || strings.Contains(page, `id="snapshot-form"`) {
+		t.Error("the merged report should not have the controls which need ReportHandler")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/agatan/accessprof"
+)
+
+func runIngest(args []string) int {
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8090", "address to receive logs and serve the report on")
+	socket := fs.String("unix", "", "path to a Unix domain socket to receive logs on, in addition to -listen")
+	logFile := fs.String("logfile", "", "file to store received logs in (on memory if empty)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: accessprof ingest [flags]")
+		fmt.Fprintln(os.Stderr, "receives logs shipped by accessprof.Shipper on /logs, and serves their report on /")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	a := &accessprof.AccessProf{LogFile: *logFile}
+	mux := http.NewServeMux()
+	mux.Handle("/logs", a.CollectorHandler())
+	mux.Handle("/", a.ReportHandler())
+
+	if *socket != "" {
+		l, err := net.Listen("unix", *socket)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer l.Close()
+		log.Printf("receiving logs on unix:%s", *socket)
+		go http.Serve(l, a.CollectorHandler())
+	}
+	log.Printf("receiving logs on http://%s/logs and serving the report on http://%s/", *listen, *listen)
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+// Command accessprof works with reports saved by accessprof.Report.Save, or served by accessprof.ReportHandler.
+//
+//	accessprof check -baseline base.json -rules rules.yaml current.json
+//	accessprof report -sort p99 -limit 10 current.json
+//	accessprof top -sort rate http://localhost:8080/accessprof
+//	accessprof ingest -listen localhost:8090 -unix /var/run/accessprof.sock
+//	accessprof collect -listen localhost:8081 http://app1:8080/accessprof http://app2:8080/accessprof
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	description string
+	run         func(args []string) int
+}
+
+var commands = map[string]*command{
+	"check":   {"compare a report against a baseline and fail on violated rules", runCheck},
+	"collect": {"serve the merged report of multiple instances", runCollect},
+	"ingest":  {"receive logs shipped by accessprof.Shipper and serve their report", runIngest},
+	"report":  {"print a saved report as a table", runReport},
+	"top":     {"show a live table of a running server's report", runTop},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: accessprof <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].description)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/agatan/accessprof"
+)
+
+// textFlags registers flags for accessprof.TextOptions.
+func textFlags(fs *flag.FlagSet) *accessprof.TextOptions {
+	opts := new(accessprof.TextOptions)
+	fs.StringVar(&opts.SortBy, "sort", "", "column to sort by (count, sum, avg, p99, body, ...)")
+	fs.BoolVar(&opts.Ascending, "asc", false, "sort in ascending order")
+	fs.IntVar(&opts.Limit, "limit", 0, "show only top N rows")
+	fs.Var((*columnsFlag)(&opts.Columns), "columns", "comma-separated list of columns (default "+strings.Join(accessprof.DefaultColumns, ",")+")")
+	fs.BoolVar(&opts.HumanUnits, "human", false, "show durations and sizes in human-friendly units")
+	fs.BoolVar(&opts.Summary, "summary", false, "show status classes and error rates of each method and path")
+	return opts
+}
+
+type columnsFlag []string
+
+func (c *columnsFlag) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *columnsFlag) Set(s string) error {
+	*c = strings.Split(s, ",")
+	return nil
+}
+
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	opts := textFlags(fs)
+	format := fs.String("format", "text", "output format (text, markdown, csv or tsv)")
+	baseline := fs.String("baseline", "", "path to the baseline report to show changes from (markdown only)")
+	sloTarget := fs.Duration("slo-target", 0, "latency target for the apdex, within_target and error_budget columns (the SLO saved in the report if 0)")
+	sloObjective := fs.Float64("slo-objective", 0, "ratio of good requests to keep for the error_budget column (e.g. 0.99; requires -slo-target)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: accessprof report [flags] report.json...")
+		fmt.Fprintln(os.Stderr, "reports of multiple processes are merged into one")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *sloObjective != 0 && *sloTarget <= 0 {
+		fmt.Fprintln(os.Stderr, "-slo-objective requires -slo-target")
+		return 2
+	}
+	if *sloObjective < 0 || *sloObjective >= 1 {
+		fmt.Fprintln(os.Stderr, "-slo-objective must be in [0, 1)")
+		return 2
+	}
+
+	reports := make([]*accessprof.Report, fs.NArg())
+	for i, path := range fs.Args() {
+		r, err := loadReportFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		reports[i] = r
+	}
+	report := accessprof.MergeReports(reports...)
+	if *sloTarget > 0 {
+		report.SetSLO(&accessprof.SLO{Target: *sloTarget, Objective: *sloObjective})
+	}
+	var err error
+	var base *accessprof.Report
+	if *baseline != "" {
+		if base, err = loadReportFile(*baseline); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	switch *format {
+	case "text":
+		err = report.RenderText(os.Stdout, opts)
+	case "markdown":
+		err = report.WriteMarkdown(os.Stdout, base)
+	case "csv":
+		err = report.WriteCSV(os.Stdout)
+	case "tsv":
+		err = report.WriteTSV(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
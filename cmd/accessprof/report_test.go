@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunReport_sloObjective(t *testing.T) {
+	f, err := ioutil.TempFile("", "accessprof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := makeReport(time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), "/a").Save(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() { os.Stdout = stdout }()
+
+	if code := runReport([]string{"-slo-objective", "0.99", f.Name()}); code != 2 {
+		t.Errorf("expected -slo-objective without -slo-target to fail, but got exit code %d", code)
+	}
+	if code := runReport([]string{"-slo-target", "100ms", "-slo-objective", "0.99", f.Name()}); code != 0 {
+		t.Errorf("expected exit code 0, but got %d", code)
+	}
+}
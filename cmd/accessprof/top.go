@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/agatan/accessprof"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+)
+
+// topRow is a row of the top table.
+type topRow struct {
+	seg *accessprof.ReportSegment
+	// rate is the number of requests per second since the previous poll
+	rate float64
+}
+
+// topColumns are the sort keys of the top table.
+var topColumns = map[string]func(*topRow) float64{
+	"count": func(r *topRow) float64 { return float64(r.seg.Count()) },
+	"rate":  func(r *topRow) float64 { return r.rate },
+	"avg":   func(r *topRow) float64 { return float64(r.seg.AvgResponseTime()) },
+	"p50":   func(r *topRow) float64 { return float64(r.seg.Percentile(50)) },
+	"p99":   func(r *topRow) float64 { return float64(r.seg.Percentile(99)) },
+	"max":   func(r *topRow) float64 { return float64(r.seg.MaxResponseTime()) },
+}
+
+type topSorter struct {
+	rows []*topRow
+	by   func(*topRow) float64
+	asc  bool
+}
+
+func (s *topSorter) Len() int      { return len(s.rows) }
+func (s *topSorter) Swap(i, j int) { s.rows[i], s.rows[j] = s.rows[j], s.rows[i] }
+func (s *topSorter) Less(i, j int) bool {
+	if s.asc {
+		return s.by(s.rows[i]) < s.by(s.rows[j])
+	}
+	return s.by(s.rows[i]) > s.by(s.rows[j])
+}
+
+func runTop(args []string) int {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "polling interval")
+	sortBy := fs.String("sort", "rate", "column to sort by (count, rate, avg, p50, p99 or max)")
+	asc := fs.Bool("asc", false, "sort in ascending order")
+	limit := fs.Int("limit", 20, "show only top N rows (0 for all)")
+	agg := fs.String("agg", "", "comma-separated list of aggregation regexps")
+	token := fs.String("token", "", "bearer token for the report endpoint (use user:password@host in the URL for basic auth)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: accessprof top [flags] http://host/accessprof")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	by, ok := topColumns[*sortBy]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown sort column %q\n", *sortBy)
+		return 2
+	}
+	u, err := reportURL(fs.Arg(0), *agg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var prev *accessprof.Report
+	var prevAt time.Time
+	for {
+		report, err := fetchReport(u, *token)
+		now := time.Now()
+		if err != nil {
+			// keep polling, e.g. while the server restarts
+			fmt.Fprint(os.Stdout, "\x1b[H\x1b[2J")
+			fmt.Fprintf(os.Stdout, "%s  %s\n%v (retrying every %s)\n", fs.Arg(0), now.Format("15:04:05"), err, *interval)
+			time.Sleep(*interval)
+			continue
+		}
+		rows := topRows(prev, report, now.Sub(prevAt))
+		var total float64
+		for _, row := range rows {
+			total += row.rate
+		}
+		sort.Stable(&topSorter{rows: rows, by: by, asc: *asc})
+		if *limit > 0 && len(rows) > *limit {
+			rows = rows[:*limit]
+		}
+		// clear the screen and move the cursor to the top
+		fmt.Fprint(os.Stdout, "\x1b[H\x1b[2J")
+		renderTop(os.Stdout, fs.Arg(0), report, rows, total, prev != nil)
+		prev, prevAt = report, now
+		time.Sleep(*interval)
+	}
+}
+
+// reportURL returns the URL of the JSON report served by accessprof.ReportHandler.
+func reportURL(base, agg string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid URL %q", base)
+	}
+	q := u.Query()
+	q.Set("format", "json")
+	if agg != "" {
+		q.Set("agg", agg)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func fetchReport(u, token string) (*accessprof.Report, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a request")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch the report")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch the report: %s", res.Status)
+	}
+	return accessprof.LoadReport(res.Body)
+}
+
+// segmentKey identifies the same segment across successive reports.
+func segmentKey(seg *accessprof.ReportSegment) string {
+	key := fmt.Sprintf("%t %s %s %d %s %s", seg.Outbound, seg.Host, seg.Method, seg.Status, seg.GRPCCode, seg.AggregationPath())
+	names := make([]string, 0, len(seg.Headers))
+	for name := range seg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key += " " + name + "=" + seg.Headers[name]
+	}
+	return key
+}
+
+// topRows computes the rate of each segment from the difference of counts between prev and cur.
+// Rates are zero at the first poll, or if the server has been reset since prev.
+func topRows(prev, cur *accessprof.Report, elapsed time.Duration) []*topRow {
+	if prev != nil && (!cur.Since.Equal(prev.Since) || cur.RequestCount() < prev.RequestCount()) {
+		prev = nil
+	}
+	prevCounts := map[string]int{}
+	if prev != nil {
+		for _, seg := range prev.Segments {
+			prevCounts[segmentKey(seg)] = seg.Count()
+		}
+	}
+	rows := make([]*topRow, len(cur.Segments))
+	for i, seg := range cur.Segments {
+		rows[i] = &topRow{seg: seg}
+		if prev != nil && elapsed > 0 {
+			if n := seg.Count() - prevCounts[segmentKey(seg)]; n > 0 {
+				rows[i].rate = float64(n) / elapsed.Seconds()
+			}
+		}
+	}
+	return rows
+}
+
+func renderTop(w io.Writer, target string, report *accessprof.Report, rows []*topRow, total float64, hasRate bool) {
+	fmt.Fprintf(w, "%s  %s\n", target, time.Now().Format("15:04:05"))
+	fmt.Fprintf(w, "%d requests since %s", report.RequestCount(), report.Since.Format(time.RFC3339))
+	if hasRate {
+		fmt.Fprintf(w, ", %.2f req/s", total)
+	}
+	fmt.Fprintln(w)
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"STATUS", "METHOD", "PATH", "COUNT", "RATE", "AVG", "P50", "P99", "MAX"})
+	for _, row := range rows {
+		seg := row.seg
+		rate := "-"
+		if hasRate {
+			rate = strconv.FormatFloat(row.rate, 'f', 2, 64) + "/s"
+		}
+		status := strconv.Itoa(seg.Status)
+		if seg.GRPCCode != "" {
+			status += " " + seg.GRPCCode
+		}
+		table.Append([]string{
+			status,
+			seg.Method,
+			seg.Host + seg.AggregationPath(),
+			strconv.Itoa(seg.Count()),
+			rate,
+			accessprof.FormatDuration(seg.AvgResponseTime()),
+			accessprof.FormatDuration(seg.Percentile(50)),
+			accessprof.FormatDuration(seg.Percentile(99)),
+			accessprof.FormatDuration(seg.MaxResponseTime()),
+		})
+	}
+	table.Render()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agatan/accessprof"
+)
+
+func makeReport(since time.Time, paths ...string) *accessprof.Report {
+	var a accessprof.AccessProf
+	for _, path := range paths {
+		a.Record(&accessprof.AccessLog{Method: "GET", Path: path, Status: 200, AccessedAt: since, ResponseTime: time.Millisecond})
+	}
+	return a.Report(nil)
+}
+
+func TestTopRows(t *testing.T) {
+	since := time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC)
+	prev := makeReport(since, "/a", "/b")
+	cur := makeReport(since, "/a", "/a", "/a", "/b")
+
+	rows := topRows(prev, cur, 2*time.Second)
+	if rows[0].rate != 1 || rows[1].rate != 0 {
+		t.Fatalf("expected rates 1/s of /a and 0/s of /b, but got %v and %v", rows[0].rate, rows[1].rate)
+	}
+
+	for _, c := range []struct {
+		name string
+		prev *accessprof.Report
+		cur  *accessprof.Report
+	}{
+		{"first poll", nil, cur},
+		{"reset to an empty report", cur, makeReport(time.Time{})},
+		{"reset with fewer requests", cur, makeReport(since.Add(time.Minute), "/a")},
+		{"reset within the same window", cur, makeReport(since, "/a")},
+	} {
+		for _, row := range topRows(c.prev, c.cur, 2*time.Second) {
+			if row.rate != 0 {
+				t.Errorf("%s: expected rates to be zero, but got %v", c.name, row.rate)
+			}
+		}
+	}
+}
+
+func TestTopRows_groupByHeaders(t *testing.T) {
+	since := time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC)
+	report := func(encodings ...string) *accessprof.Report {
+		a := accessprof.AccessProf{GroupByHeaders: []string{"res:Content-Encoding"}}
+		for _, encoding := range encodings {
+			a.Record(&accessprof.AccessLog{
+				Method: "GET", Path: "/a", Status: 200, AccessedAt: since, ResponseTime: time.Millisecond,
+				ResponseHeaders: map[string]string{"Content-Encoding": encoding},
+			})
+		}
+		return a.Report(nil)
+	}
+	prev := report("gzip", "br", "br")
+	cur := report("gzip", "gzip", "gzip", "br", "br")
+
+	rates := map[string]float64{}
+	for _, row := range topRows(prev, cur, 2*time.Second) {
+		rates[row.seg.Headers["res:Content-Encoding"]] = row.rate
+	}
+	if rates["gzip"] != 1 || rates["br"] != 0 {
+		t.Fatalf("expected rates 1/s of gzip and 0/s of br, but got %v", rates)
+	}
+}
+
+func TestRenderTop(t *testing.T) {
+	report := makeReport(time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), "/a")
+	var buf bytes.Buffer
+	renderTop(&buf, "http://localhost/accessprof", report, topRows(nil, report, 0), 0, false)
+	if s := buf.String(); !strings.Contains(s, accessprof.FormatDuration(time.Millisecond)) || !strings.Contains(s, "1 requests since") {
+		t.Fatalf("unexpected output:\n%s", s)
+	}
+}
+
+func TestReportURL(t *testing.T) {
+	u, err := reportURL("http://localhost:8080/accessprof", `/users/\d+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "http://localhost:8080/accessprof?agg=%2Fusers%2F%5Cd%2B&format=json"; u != expected {
+		t.Fatalf("expected %s, but got %s", expected, u)
+	}
+}
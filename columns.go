@@ -0,0 +1,271 @@
+package accessprof
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// column is a column of tabular reports (text, HTML and so on).
+type column struct {
+	key    string
+	header string
+	// value returns the numeric value of the column (nil for non-numeric columns)
+	value func(*ReportSegment) float64
+	// text formats the column for text reports
+	text func(seg *ReportSegment, human bool) string
+	// duration is true if value is a time.Duration
+	duration bool
+	// higherIsBetter is true if an increase of value is an improvement (e.g. apdex)
+	higherIsBetter bool
+}
+
+// DefaultColumns is the list of column keys rendered by Report.String.
+// Tables of outbound segments have the host column in addition.
+var DefaultColumns = []string{"status", "method", "path", "count", "min", "max", "sum", "avg", "min_body", "max_body", "sum_body", "avg_body"}
+
+func durationColumn(key, header string, f func(*ReportSegment) time.Duration) *column {
+	return &column{
+		key:    key,
+		header: header,
+		value:  func(seg *ReportSegment) float64 { return float64(f(seg)) },
+		text: func(seg *ReportSegment, human bool) string {
+			if human {
+				return humanDuration(f(seg))
+			}
+			return f(seg).String()
+		},
+		duration: true,
+	}
+}
+
+func bodyColumn(key, header string, f func(*ReportSegment) int) *column {
+	return &column{
+		key:    key,
+		header: header,
+		value:  func(seg *ReportSegment) float64 { return float64(f(seg)) },
+		text: func(seg *ReportSegment, human bool) string {
+			if human {
+				return humanBytes(float64(f(seg)))
+			}
+			return strconv.Itoa(f(seg))
+		},
+	}
+}
+
+func floatColumn(key, header string, f func(*ReportSegment) float64) *column {
+	return &column{
+		key:    key,
+		header: header,
+		value:  f,
+		text: func(seg *ReportSegment, human bool) string {
+			return strconv.FormatFloat(f(seg), 'f', 2, 64)
+		},
+	}
+}
+
+// sloColumn is a column which needs the SLO (and its objective if needsObjective) of the report,
+// and is "-" if the report has none.
+func sloColumn(key, header string, f func(*ReportSegment) float64, format func(float64) string, needsObjective bool) *column {
+	return &column{
+		key:    key,
+		header: header,
+		value:  f,
+		text: func(seg *ReportSegment, human bool) string {
+			if seg.slo == nil || needsObjective && seg.slo.Objective == 0 {
+				return "-"
+			}
+			return format(f(seg))
+		},
+		higherIsBetter: true,
+	}
+}
+
+var columns = []*column{
+	{
+		key:    "status",
+		header: "STATUS",
+		value:  func(seg *ReportSegment) float64 { return float64(seg.Status) },
+		text:   func(seg *ReportSegment, human bool) string { return seg.statusText() },
+	},
+	{
+		key:    "method",
+		header: "METHOD",
+		text:   func(seg *ReportSegment, human bool) string { return seg.Method },
+	},
+	{
+		key:    "path",
+		header: "PATH",
+		text:   func(seg *ReportSegment, human bool) string { return seg.AggregationPath() },
+	},
+	{
+		key:    "host",
+		header: "HOST",
+		text:   func(seg *ReportSegment, human bool) string { return seg.Host },
+	},
+	{
+		key:    "count",
+		header: "COUNT",
+		value:  func(seg *ReportSegment) float64 { return float64(seg.Count()) },
+		text:   func(seg *ReportSegment, human bool) string { return strconv.Itoa(seg.Count()) },
+	},
+	floatColumn("rps", "RPS", (*ReportSegment).RequestsPerSecond),
+	{
+		key:    "max_concurrency",
+		header: "MAX(CONCURRENCY)",
+		value:  func(seg *ReportSegment) float64 { return float64(seg.MaxConcurrency()) },
+		text: func(seg *ReportSegment, human bool) string {
+			// concurrency is unknown unless requests are tracked in flight (see AccessLog.InFlight)
+			if seg.MaxConcurrency() == 0 {
+				return "-"
+			}
+			return strconv.Itoa(seg.MaxConcurrency())
+		},
+	},
+	floatColumn("avg_concurrency", "AVG(CONCURRENCY)", (*ReportSegment).AvgConcurrency),
+	sloColumn("apdex", "APDEX", (*ReportSegment).Apdex, func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }, false),
+	sloColumn("within_target", "WITHIN TARGET", (*ReportSegment).WithinTarget, formatPercent, false),
+	sloColumn("error_budget", "ERROR BUDGET", (*ReportSegment).ErrorBudget, formatPercent, true),
+	durationColumn("min", "MIN", (*ReportSegment).MinResponseTime),
+	durationColumn("max", "MAX", (*ReportSegment).MaxResponseTime),
+	durationColumn("sum", "SUM", (*ReportSegment).SumResponseTime),
+	durationColumn("avg", "AVG", (*ReportSegment).AvgResponseTime),
+	durationColumn("p50", "P50", func(seg *ReportSegment) time.Duration { return seg.Percentile(50) }),
+	durationColumn("p90", "P90", func(seg *ReportSegment) time.Duration { return seg.Percentile(90) }),
+	durationColumn("p95", "P95", func(seg *ReportSegment) time.Duration { return seg.Percentile(95) }),
+	durationColumn("p99", "P99", func(seg *ReportSegment) time.Duration { return seg.Percentile(99) }),
+	{
+		key:    "headers",
+		header: "HEADERS",
+		text:   func(seg *ReportSegment, human bool) string { return seg.headersText() },
+	},
+	{
+		key:    "instances",
+		header: "INSTANCES",
+		text:   func(seg *ReportSegment, human bool) string { return seg.instancesText() },
+	},
+	{
+		key:    "histogram",
+		header: "LATENCY",
+		text:   func(seg *ReportSegment, human bool) string { return sparkline(seg.Histogram()) },
+	},
+	bodyColumn("min_body", "MIN(BODY)", (*ReportSegment).MinBody),
+	bodyColumn("max_body", "MAX(BODY)", (*ReportSegment).MaxBody),
+	bodyColumn("sum_body", "SUM(BODY)", (*ReportSegment).SumBody),
+	{
+		key:    "avg_body",
+		header: "AVG(BODY)",
+		value:  (*ReportSegment).AvgBody,
+		text: func(seg *ReportSegment, human bool) string {
+			if human {
+				return humanBytes(seg.AvgBody())
+			}
+			return strconv.FormatFloat(seg.AvgBody(), 'f', 3, 64)
+		},
+	},
+}
+
+// columnAliases maps shorthand keys accepted as sort keys to column keys.
+var columnAliases = map[string]string{
+	"body": "sum_body",
+}
+
+func lookupColumn(key string) (*column, error) {
+	if alias, ok := columnAliases[key]; ok {
+		key = alias
+	}
+	for _, c := range columns {
+		if c.key == key {
+			return c, nil
+		}
+	}
+	return nil, errors.Errorf("unknown column %q", key)
+}
+
+func lookupColumns(keys []string) ([]*column, error) {
+	if len(keys) == 0 {
+		keys = DefaultColumns
+	}
+	return lookupColumnKeys(keys)
+}
+
+// lookupOutboundColumns is the same as lookupColumns, but prepends the host column if keys does not have it.
+func lookupOutboundColumns(keys []string) ([]*column, error) {
+	if len(keys) == 0 {
+		keys = DefaultColumns
+	}
+	for _, key := range keys {
+		if key == "host" {
+			return lookupColumnKeys(keys)
+		}
+	}
+	return lookupColumnKeys(append([]string{"host"}, keys...))
+}
+
+func lookupColumnKeys(keys []string) ([]*column, error) {
+	cs := make([]*column, len(keys))
+	for i, key := range keys {
+		c, err := lookupColumn(key)
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+	return cs, nil
+}
+
+type segmentSorter struct {
+	segs      []*ReportSegment
+	by        *column
+	ascending bool
+}
+
+func (s *segmentSorter) Len() int      { return len(s.segs) }
+func (s *segmentSorter) Swap(i, j int) { s.segs[i], s.segs[j] = s.segs[j], s.segs[i] }
+func (s *segmentSorter) Less(i, j int) bool {
+	a, b := s.segs[i], s.segs[j]
+	if !s.ascending {
+		a, b = b, a
+	}
+	if s.by.value != nil {
+		return s.by.value(a) < s.by.value(b)
+	}
+	return s.by.text(a, false) < s.by.text(b, false)
+}
+
+// sortSegments returns a copy of segs sorted by the column.
+func sortSegments(segs []*ReportSegment, by *column, ascending bool) []*ReportSegment {
+	sorted := make([]*ReportSegment, len(segs))
+	copy(sorted, segs)
+	sort.Stable(&segmentSorter{segs: sorted, by: by, ascending: ascending})
+	return sorted
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return strconv.FormatFloat(d.Seconds(), 'f', 2, 64) + "s"
+	case d >= time.Millisecond:
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64) + "ms"
+	case d >= time.Microsecond:
+		return strconv.FormatFloat(float64(d)/float64(time.Microsecond), 'f', 2, 64) + "µs"
+	default:
+		return strconv.FormatInt(int64(d), 10) + "ns"
+	}
+}
+
+func humanBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if n == math.Trunc(n) {
+		return strconv.FormatFloat(n, 'f', 0, 64) + units[i]
+	}
+	return strconv.FormatFloat(n, 'f', 1, 64) + units[i]
+}
@@ -0,0 +1,67 @@
+package accessprof
+
+import (
+	"time"
+)
+
+// ComparisonRow pairs up the segments of two reports which have the same status, method, aggregation path and destination.
+// Base or Target is nil if the segment appears only in the other report.
+type ComparisonRow struct {
+	Status   int
+	GRPCCode string
+	Method   string
+	Path     string
+	Outbound bool
+	Host     string
+	Base     *ReportSegment
+	Target   *ReportSegment
+}
+
+// Comparison is the result of CompareReports.
+type Comparison struct {
+	Base   *Report
+	Target *Report
+	Rows   []*ComparisonRow
+}
+
+// CompareReports pairs up the segments of base and target.
+func CompareReports(base, target *Report) *Comparison {
+	c := &Comparison{Base: base, Target: target}
+	rows := map[string]*ComparisonRow{}
+	row := func(seg *ReportSegment) *ComparisonRow {
+		key := seg.key()
+		if r, ok := rows[key]; ok {
+			return r
+		}
+		r := &ComparisonRow{Status: seg.Status, GRPCCode: seg.GRPCCode, Method: seg.Method, Path: seg.AggregationPath(), Outbound: seg.Outbound, Host: seg.Host}
+		rows[key] = r
+		c.Rows = append(c.Rows, r)
+		return r
+	}
+	for _, seg := range base.Segments {
+		row(seg).Base = seg
+	}
+	for _, seg := range target.Segments {
+		row(seg).Target = seg
+	}
+	return c
+}
+
+// AvgResponseTimeChange returns the relative change of the average response time from Base to Target.
+// It returns false if the segment is missing in either report.
+func (r *ComparisonRow) AvgResponseTimeChange() (float64, bool) {
+	if r.Base == nil || r.Target == nil {
+		return 0, false
+	}
+	return relativeChange(r.Base.AvgResponseTime(), r.Target.AvgResponseTime()), true
+}
+
+func relativeChange(base, target time.Duration) float64 {
+	if base == 0 {
+		if target == 0 {
+			return 0
+		}
+		return 1
+	}
+	return float64(target-base) / float64(base)
+}
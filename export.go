@@ -0,0 +1,128 @@
+package accessprof
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WriteCSV writes the report as CSV with a header row.
+// The columns are the same as Report.String, but durations and sizes are raw numbers (nanoseconds and bytes).
+// If the report has outbound segments, the host column is prepended (empty for inbound segments).
+func (r *Report) WriteCSV(w io.Writer) error {
+	return r.writeDelimited(w, ',')
+}
+
+// WriteTSV is the same as WriteCSV, but separates fields by tabs.
+func (r *Report) WriteTSV(w io.Writer) error {
+	return r.writeDelimited(w, '\t')
+}
+
+func (r *Report) writeDelimited(w io.Writer, comma rune) error {
+	cs, err := lookupColumns(nil)
+	if len(r.OutboundSegments()) != 0 {
+		cs, err = lookupOutboundColumns(nil)
+	}
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	header := make([]string, len(cs))
+	for i, c := range cs {
+		header[i] = c.header
+	}
+	cw.Write(header)
+	for _, seg := range r.Segments {
+		row := make([]string, len(cs))
+		for i, c := range cs {
+			row[i] = c.raw(seg)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "failed to write report")
+}
+
+// raw formats the column as a raw number if it is numeric.
+func (c *column) raw(seg *ReportSegment) string {
+	if c.value == nil {
+		return c.text(seg, false)
+	}
+	return strconv.FormatFloat(c.value(seg), 'f', -1, 64)
+}
+
+// WriteMarkdown writes the report as a GitHub-flavoured markdown table with the same columns as Report.String.
+// If baseline is not nil, numeric cells are annotated with arrows and relative changes from the same segment of baseline.
+// Outbound segments are written in a separate table.
+func (r *Report) WriteMarkdown(w io.Writer, baseline *Report) error {
+	bases := map[*ReportSegment]*ReportSegment{}
+	if baseline != nil {
+		for _, row := range CompareReports(baseline, r).Rows {
+			if row.Target != nil && row.Base != nil {
+				bases[row.Target] = row.Base
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	cs, err := lookupColumns(nil)
+	if err != nil {
+		return err
+	}
+	writeMarkdownTable(&buf, r.InboundSegments(), cs, bases)
+	if outbound := r.OutboundSegments(); len(outbound) != 0 {
+		if cs, err = lookupOutboundColumns(nil); err != nil {
+			return err
+		}
+		buf.WriteString("\n**Outbound**\n\n")
+		writeMarkdownTable(&buf, outbound, cs, bases)
+	}
+	_, err = buf.WriteTo(w)
+	return errors.Wrap(err, "failed to write report")
+}
+
+func writeMarkdownTable(buf *bytes.Buffer, segs []*ReportSegment, cs []*column, bases map[*ReportSegment]*ReportSegment) {
+	buf.WriteString("|")
+	for _, c := range cs {
+		buf.WriteString(" " + c.header + " |")
+	}
+	buf.WriteString("\n|")
+	for _, c := range cs {
+		if c.value != nil {
+			buf.WriteString(" ---: |")
+		} else {
+			buf.WriteString(" --- |")
+		}
+	}
+	buf.WriteString("\n")
+	for _, seg := range segs {
+		buf.WriteString("|")
+		for _, c := range cs {
+			cell := markdownEscaper.Replace(c.text(seg, false))
+			if base, ok := bases[seg]; ok && c.value != nil && c.key != "status" {
+				cell += markdownChange(c.value(base), c.value(seg))
+			}
+			buf.WriteString(" " + cell + " |")
+		}
+		buf.WriteString("\n")
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`")
+
+func markdownChange(base, current float64) string {
+	change := relativeIncrease(base, current)
+	switch {
+	case change > 0:
+		return " ↑ +" + strconv.FormatFloat(change*100, 'f', 1, 64) + "%"
+	case change < 0:
+		return " ↓ " + strconv.FormatFloat(change*100, 'f', 1, 64) + "%"
+	default:
+		return ""
+	}
+}
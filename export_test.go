@@ -0,0 +1,69 @@
+package accessprof
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport_WriteCSV(t *testing.T) {
+	logs := makeLogs("GET", "/a,b", 200, time.Millisecond, 3*time.Millisecond)
+	logs[0].ResponseBodySize = 10
+	logs[1].ResponseBodySize = 15
+	report := buildReport(logs, nil, reportOptions{})
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `STATUS,METHOD,PATH,COUNT,MIN,MAX,SUM,AVG,MIN(BODY),MAX(BODY),SUM(BODY),AVG(BODY)
+200,GET,"/a,b",2,1000000,3000000,4000000,2000000,10,15,25,12.5
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := report.WriteTSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "STATUS\tMETHOD\tPATH\t") {
+		t.Fatalf("unexpected TSV: %s", buf.String())
+	}
+}
+
+func TestAccessProf_ServeHTTP_csv(t *testing.T) {
+	var a AccessProf
+	server := httptest.NewServer(a.Wrap(testHandler, "/accessprof"))
+	defer server.Close()
+
+	http.Get(server.URL)
+	resp, err := http.Get(server.URL + "/accessprof?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("expected text/csv, but got %q", ct)
+	}
+}
+
+func TestReport_WriteMarkdown(t *testing.T) {
+	base := buildReport(makeLogs("GET", `/a|\d+`, 200, time.Millisecond), nil, reportOptions{})
+	current := buildReport(makeLogs("GET", `/a|\d+`, 200, 2*time.Millisecond), nil, reportOptions{})
+
+	var buf bytes.Buffer
+	if err := current.WriteMarkdown(&buf, base); err != nil {
+		t.Fatal(err)
+	}
+	expected := `| STATUS | METHOD | PATH | COUNT | MIN | MAX | SUM | AVG | MIN(BODY) | MAX(BODY) | SUM(BODY) | AVG(BODY) |
+| ---: | --- | --- | ---: | ---: | ---: | ---: | ---: | ---: | ---: | ---: | ---: |
+| 200 | GET | /a\|\\d+ | 1 | 2ms ↑ +100.0% | 2ms ↑ +100.0% | 2ms ↑ +100.0% | 2ms ↑ +100.0% | 0 | 0 | 0 | 0.000 |
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+}
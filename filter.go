@@ -0,0 +1,138 @@
+package accessprof
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Filter decides which requests are recorded.
+// A request is recorded if its path, method and user agent each match any of their include rules
+// (or there are no include rules for them), and it matches none of the exclude rules.
+type Filter struct {
+	IncludePathPrefixes []string
+	IncludePathRegexps  []*regexp.Regexp
+	ExcludePathPrefixes []string
+	ExcludePathRegexps  []*regexp.Regexp
+	IncludeMethods      []string
+	ExcludeMethods      []string
+	// IncludeUserAgents and ExcludeUserAgents apply only to requests whose user agent is known,
+	// i.e. requests recorded by Handler, Middleware and AccessProf.Begin
+	IncludeUserAgents []*regexp.Regexp
+	ExcludeUserAgents []*regexp.Regexp
+	// ExcludeHeaders excludes requests whose header (by name) matches the regexp.
+	// It applies only to requests recorded by Handler, Middleware and AccessProf.Begin.
+	ExcludeHeaders map[string]*regexp.Regexp
+}
+
+// Allow reports whether a request should be recorded. userAgent is the User-Agent header of the request,
+// so requests without it are rejected if IncludeUserAgents is set.
+func (f *Filter) Allow(method, path, userAgent string) bool {
+	if f == nil {
+		return true
+	}
+	if !f.allowRequest(method, path) {
+		return false
+	}
+	if len(f.IncludeUserAgents) != 0 && !matchAny(userAgent, f.IncludeUserAgents) {
+		return false
+	}
+	return !matchAny(userAgent, f.ExcludeUserAgents)
+}
+
+// allowRequest reports whether a request whose user agent is unknown should be recorded.
+func (f *Filter) allowRequest(method, path string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.IncludePathPrefixes) != 0 || len(f.IncludePathRegexps) != 0 {
+		if !hasAnyPrefix(path, f.IncludePathPrefixes) && !matchAny(path, f.IncludePathRegexps) {
+			return false
+		}
+	}
+	if hasAnyPrefix(path, f.ExcludePathPrefixes) || matchAny(path, f.ExcludePathRegexps) {
+		return false
+	}
+	return !(len(f.IncludeMethods) != 0 && !contains(f.IncludeMethods, method) || contains(f.ExcludeMethods, method))
+}
+
+// allowHeaders reports whether request headers, looked up by header, match none of ExcludeHeaders.
+func (f *Filter) allowHeaders(header func(name string) string) bool {
+	if f == nil {
+		return true
+	}
+	for name, re := range f.ExcludeHeaders {
+		if v := header(name); v != "" && re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rules describes the rules of the filter, e.g. "exclude path prefix /static/".
+func (f *Filter) Rules() []string {
+	if f == nil {
+		return nil
+	}
+	var rules []string
+	for _, p := range f.IncludePathPrefixes {
+		rules = append(rules, "include path prefix "+p)
+	}
+	for _, re := range f.IncludePathRegexps {
+		rules = append(rules, "include path "+re.String())
+	}
+	for _, p := range f.ExcludePathPrefixes {
+		rules = append(rules, "exclude path prefix "+p)
+	}
+	for _, re := range f.ExcludePathRegexps {
+		rules = append(rules, "exclude path "+re.String())
+	}
+	for _, m := range f.IncludeMethods {
+		rules = append(rules, "include method "+m)
+	}
+	for _, m := range f.ExcludeMethods {
+		rules = append(rules, "exclude method "+m)
+	}
+	for _, re := range f.IncludeUserAgents {
+		rules = append(rules, "include user agent "+re.String())
+	}
+	for _, re := range f.ExcludeUserAgents {
+		rules = append(rules, "exclude user agent "+re.String())
+	}
+	names := make([]string, 0, len(f.ExcludeHeaders))
+	for name := range f.ExcludeHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rules = append(rules, "exclude header "+name+" "+f.ExcludeHeaders[name].String())
+	}
+	return rules
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(s string, res []*regexp.Regexp) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package accessprof
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// requestHeaderPrefix and responseHeaderPrefix qualify header names in AccessProf.GroupByHeaders
+	requestHeaderPrefix  = "req:"
+	responseHeaderPrefix = "res:"
+
+	requestHeaderLabelPrefix  = "req_header_"
+	responseHeaderLabelPrefix = "res_header_"
+)
+
+// captureHeaders returns the values of names looked up by header (nil if none of them is set).
+func captureHeaders(header func(name string) string, names []string) map[string]string {
+	var captured map[string]string
+	for _, name := range names {
+		v := header(name)
+		if v == "" {
+			continue
+		}
+		if captured == nil {
+			captured = map[string]string{}
+		}
+		captured[http.CanonicalHeaderKey(name)] = v
+	}
+	return captured
+}
+
+// header returns the captured header qualified by "req:" or "res:" (e.g. "res:Content-Encoding").
+func (l *AccessLog) header(qualified string) string {
+	switch {
+	case strings.HasPrefix(qualified, requestHeaderPrefix):
+		return l.RequestHeaders[http.CanonicalHeaderKey(strings.TrimPrefix(qualified, requestHeaderPrefix))]
+	case strings.HasPrefix(qualified, responseHeaderPrefix):
+		return l.ResponseHeaders[http.CanonicalHeaderKey(strings.TrimPrefix(qualified, responseHeaderPrefix))]
+	}
+	return ""
+}
+
+// groupingHeaders returns the values of headers to group l by (nil if names is empty).
+func (l *AccessLog) groupingHeaders(names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(names))
+	for _, name := range names {
+		headers[name] = l.header(name)
+	}
+	return headers
+}
+
+// headersLTSV formats captured headers as LTSV columns, e.g. "\treq_header_x-request-id:abc".
+func (l *AccessLog) headersLTSV() string {
+	var s string
+	for _, prefix := range []struct {
+		label   string
+		headers map[string]string
+	}{
+		{requestHeaderLabelPrefix, l.RequestHeaders},
+		{responseHeaderLabelPrefix, l.ResponseHeaders},
+	} {
+		for _, name := range sortedKeys(prefix.headers) {
+			s += "\t" + prefix.label + strings.ToLower(name) + ":" + ltsvEscaper.Replace(prefix.headers[name])
+		}
+	}
+	return s
+}
+
+// parseHeaders restores captured headers from LTSV columns.
+func (l *AccessLog) parseHeaders(table map[string]string) {
+	for label, v := range table {
+		switch {
+		case strings.HasPrefix(label, requestHeaderLabelPrefix):
+			if l.RequestHeaders == nil {
+				l.RequestHeaders = map[string]string{}
+			}
+			l.RequestHeaders[http.CanonicalHeaderKey(strings.TrimPrefix(label, requestHeaderLabelPrefix))] = v
+		case strings.HasPrefix(label, responseHeaderLabelPrefix):
+			if l.ResponseHeaders == nil {
+				l.ResponseHeaders = map[string]string{}
+			}
+			l.ResponseHeaders[http.CanonicalHeaderKey(strings.TrimPrefix(label, responseHeaderLabelPrefix))] = v
+		}
+	}
+}
+
+// headersText formats the grouping headers of the segment like "res:Content-Encoding=gzip".
+func (seg *ReportSegment) headersText() string {
+	return formatHeaders(seg.Headers)
+}
+
+func formatHeaders(headers map[string]string) string {
+	parts := make([]string, 0, len(headers))
+	for _, name := range sortedKeys(headers) {
+		parts = append(parts, name+"="+headers[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SlowestLogs returns up to n logs of the segment in descending order of response time
+// (none for reports loaded by LoadReport, which have no raw logs).
+// Logs of the same response time are in the order of the segment.
+func (seg *ReportSegment) SlowestLogs(n int) []*AccessLog {
+	if n <= 0 {
+		return nil
+	}
+	// keep the n slowest logs by insertion, instead of sorting all logs of the segment for every render
+	logs := make([]*AccessLog, 0, n)
+	for _, l := range seg.AccessLogs {
+		if len(logs) == n && logs[n-1].ResponseTime >= l.ResponseTime {
+			continue
+		}
+		i := sort.Search(len(logs), func(i int) bool { return logs[i].ResponseTime < l.ResponseTime })
+		if len(logs) < n {
+			logs = append(logs, nil)
+		}
+		copy(logs[i+1:], logs[i:])
+		logs[i] = l
+	}
+	return logs
+}
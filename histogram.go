@@ -0,0 +1,85 @@
+package accessprof
+
+import (
+	"sort"
+	"time"
+)
+
+// histogramBounds are the bucket boundaries of latency histograms, in a 1-2-5 series from 1µs to 50s.
+var histogramBounds = func() []time.Duration {
+	var bounds []time.Duration
+	for d := time.Microsecond; d <= 10*time.Second; d *= 10 {
+		bounds = append(bounds, d, 2*d, 5*d)
+	}
+	return bounds
+}()
+
+// HistogramBucket counts response times in [Min, Max).
+// Max of the last bucket is 0, which means the bucket has no upper bound.
+type HistogramBucket struct {
+	Min   time.Duration `json:"min_nano"`
+	Max   time.Duration `json:"max_nano"`
+	Count int64         `json:"count"`
+}
+
+// histogram returns the buckets between the first and the last non-empty ones.
+// Counts are approximate near bucket boundaries, since the sketch keeps response times with relative error.
+func (s *latencySketch) histogram() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(histogramBounds)+1)
+	for i := range buckets {
+		if i > 0 {
+			buckets[i].Min = histogramBounds[i-1]
+		}
+		if i < len(histogramBounds) {
+			buckets[i].Max = histogramBounds[i]
+		}
+	}
+	buckets[0].Count = s.zero
+	for i, n := range s.bins {
+		v := sketchValue(i)
+		j := sort.Search(len(histogramBounds), func(j int) bool { return histogramBounds[j] > v })
+		buckets[j].Count += n
+	}
+
+	first, last := -1, -1
+	for i, b := range buckets {
+		if b.Count == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if first < 0 {
+		return nil
+	}
+	return buckets[first : last+1]
+}
+
+// Histogram returns the log-bucketed histogram of response times, trimmed to the range of recorded values.
+func (seg *ReportSegment) Histogram() []HistogramBucket {
+	seg.ensureStats()
+	return seg.latency.histogram()
+}
+
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders bucket counts as a line of block characters scaled to the largest count.
+func sparkline(buckets []HistogramBucket) string {
+	var max int64
+	for _, b := range buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	line := make([]rune, len(buckets))
+	for i, b := range buckets {
+		level := 0
+		if b.Count > 0 {
+			level = 1 + int(b.Count*int64(len(sparkLevels)-2)/max)
+		}
+		line[i] = sparkLevels[level]
+	}
+	return string(line)
+}
@@ -0,0 +1,135 @@
+package accessprof
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MergeReports merges reports of multiple processes (e.g. replicas loaded by LoadReport) into one report.
+// Segments with the same status, method, aggregation path and destination are merged into one segment,
+// and their percentiles are estimated from the merged latency sketches with the same accuracy as a single process.
+func MergeReports(reports ...*Report) *Report {
+	merged := new(Report)
+	index := map[string]*ReportSegment{}
+	aggregates := map[string]bool{}
+	for _, r := range reports {
+		if !r.Since.IsZero() && (merged.Since.IsZero() || merged.Since.After(r.Since)) {
+			merged.Since = r.Since
+		}
+		if merged.Until.Before(r.Until) {
+			merged.Until = r.Until
+		}
+		merged.Overflowed += r.Overflowed
+		if merged.slo == nil {
+			merged.slo = r.slo
+		}
+		for _, agg := range r.Aggregates {
+			if !aggregates[agg.String()] {
+				aggregates[agg.String()] = true
+				merged.Aggregates = append(merged.Aggregates, agg)
+			}
+		}
+		for _, seg := range r.Segments {
+			key := seg.key()
+			m, ok := index[key]
+			if !ok {
+				m = &ReportSegment{
+					Method:     seg.Method,
+					Path:       seg.Path,
+					PathRegexp: seg.PathRegexp,
+					Status:     seg.Status,
+					GRPCCode:   seg.GRPCCode,
+					Outbound:   seg.Outbound,
+					Host:       seg.Host,
+					Headers:    seg.Headers,
+				}
+				index[key] = m
+				merged.Segments = append(merged.Segments, m)
+			}
+			m.merge(seg)
+		}
+	}
+	merged.setWindow()
+	merged.SetSLO(merged.slo)
+	return merged
+}
+
+// merge adds the statistics and logs of o to seg.
+func (seg *ReportSegment) merge(o *ReportSegment) {
+	o.ensureStats()
+	if o.count == 0 {
+		return
+	}
+	seg.AccessLogs = append(seg.AccessLogs, o.AccessLogs...)
+	if seg.count == 0 || seg.minResponseTime > o.minResponseTime {
+		seg.minResponseTime = o.minResponseTime
+	}
+	if seg.maxResponseTime < o.maxResponseTime {
+		seg.maxResponseTime = o.maxResponseTime
+	}
+	if seg.count == 0 || seg.minBody > o.minBody {
+		seg.minBody = o.minBody
+	}
+	if seg.maxBody < o.maxBody {
+		seg.maxBody = o.maxBody
+	}
+	seg.sumResponseTime += o.sumResponseTime
+	seg.sumBody += o.sumBody
+	seg.latency.merge(&o.latency)
+	// concurrency is measured by each process, so the merged maximum is the maximum of any process
+	if seg.maxInFlight < o.maxInFlight {
+		seg.maxInFlight = o.maxInFlight
+	}
+	seg.count += o.count
+}
+
+// MergeInstances merges reports of named instances in the same way as MergeReports,
+// and breaks down the count of each segment by instance into ReportSegment.Instances.
+func MergeInstances(reports map[string]*Report) *Report {
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ordered := make([]*Report, len(names))
+	for i, name := range names {
+		ordered[i] = reports[name]
+	}
+	merged := MergeReports(ordered...)
+
+	index := map[string]*ReportSegment{}
+	for _, seg := range merged.Segments {
+		seg.Instances = map[string]int{}
+		index[seg.key()] = seg
+	}
+	for _, name := range names {
+		for _, seg := range reports[name].Segments {
+			index[seg.key()].Instances[name] += seg.Count()
+		}
+	}
+	return merged
+}
+
+func (r *Report) hasInstances() bool {
+	for _, seg := range r.Segments {
+		if seg.Instances != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// instancesText formats Instances like "app1=40 app2=60".
+func (seg *ReportSegment) instancesText() string {
+	names := make([]string, 0, len(seg.Instances))
+	for name := range seg.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strconv.Itoa(seg.Instances[name])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,141 @@
+package accessprof
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var pathPlaceholders = []struct {
+	placeholder string
+	re          *regexp.Regexp
+}{
+	{"{id}", regexp.MustCompile(`^\d+$`)},
+	{"{uuid}", regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)},
+	{"{date}", regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)},
+	{"{hex}", regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)},
+	{"{token}", regexp.MustCompile(`^[A-Za-z0-9_\-+]{20,}={0,2}$`)},
+}
+
+var (
+	hasDigit  = regexp.MustCompile(`\d`)
+	hasLetter = regexp.MustCompile(`[A-Za-z]`)
+)
+
+// NormalizePath replaces each path segment which looks like a numeric ID, UUID, date, hex hash or base64-ish token
+// with a placeholder, e.g. "/users/123/posts/2017-12-02" becomes "/users/{id}/posts/{date}".
+func NormalizePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = normalizePathSegment(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func normalizePathSegment(s string) string {
+	for _, p := range pathPlaceholders {
+		if !p.re.MatchString(s) {
+			continue
+		}
+		// Long words like "account_settings_page_v2" are not tokens; tokens mix letters and digits.
+		if p.placeholder == "{token}" && !(hasDigit.MatchString(s) && hasLetter.MatchString(s)) {
+			continue
+		}
+		return p.placeholder
+	}
+	return s
+}
+
+// DefaultSuggestionCardinality is the number of distinct values at a path position
+// above which SuggestAggregates regards the position as variable.
+const DefaultSuggestionCardinality = 5
+
+// SuggestAggregates proposes regexps for aggregates, generalizing path positions
+// which have more than minCardinality distinct values among the paths sharing the preceding positions.
+func (r *Report) SuggestAggregates(minCardinality int) []string {
+	byLength := map[int][][]string{}
+	for _, seg := range r.Segments {
+		if seg.PathRegexp != nil {
+			continue
+		}
+		parts := strings.Split(seg.Path, "/")
+		byLength[len(parts)] = append(byLength[len(parts)], parts)
+	}
+
+	var suggestions []string
+	seen := map[string]bool{}
+	for _, paths := range byLength {
+		patterns := make([][]string, len(paths))
+		for i, parts := range paths {
+			patterns[i] = make([]string, len(parts))
+			for j, part := range parts {
+				patterns[i][j] = suggestionLiteral(part)
+			}
+		}
+		generalized := make([]bool, len(paths))
+		for pos := 0; pos < len(paths[0]); pos++ {
+			groups := map[string][]int{}
+			for i, pattern := range patterns {
+				prefix := strings.Join(pattern[:pos], "/")
+				groups[prefix] = append(groups[prefix], i)
+			}
+			for _, group := range groups {
+				values := map[string]bool{}
+				for _, i := range group {
+					values[paths[i][pos]] = true
+				}
+				if len(values) <= minCardinality {
+					continue
+				}
+				class := valueClass(values)
+				for _, i := range group {
+					patterns[i][pos] = class
+					generalized[i] = true
+				}
+			}
+		}
+		for i, pattern := range patterns {
+			s := strings.Join(pattern, "/")
+			if generalized[i] && !seen[s] {
+				seen[s] = true
+				suggestions = append(suggestions, s)
+			}
+		}
+	}
+	sort.Strings(suggestions)
+	return suggestions
+}
+
+// suggestionLiteral returns a regexp matching the path segment part of raw paths.
+// Placeholders of NormalizePath match any segment, and commas are escaped,
+// so that suggestions can be joined into the comma-separated agg parameter (see splitAggregates).
+func suggestionLiteral(part string) string {
+	for _, p := range pathPlaceholders {
+		if part == p.placeholder {
+			return `[^/]+`
+		}
+	}
+	return strings.Replace(regexp.QuoteMeta(part), ",", `\,`, -1)
+}
+
+var (
+	digitsValue = regexp.MustCompile(`^\d+$`)
+	hexValue    = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// valueClass returns the narrowest regexp matching all values.
+func valueClass(values map[string]bool) string {
+	digits, hex := true, true
+	for v := range values {
+		digits = digits && digitsValue.MatchString(v)
+		hex = hex && hexValue.MatchString(v)
+	}
+	switch {
+	case digits:
+		return `\d+`
+	case hex:
+		return `[0-9a-fA-F]+`
+	default:
+		return `[^/]+`
+	}
+}
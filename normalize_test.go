@@ -0,0 +1,80 @@
+package accessprof
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	for _, c := range []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"/users/123", "/users/{id}"},
+		{"/users/123/posts/2017-12-02", "/users/{id}/posts/{date}"},
+		{"/items/6ba7b810-9dad-11d1-80b4-00c04fd430c8", "/items/{uuid}"},
+		{"/blobs/d41d8cd98f00b204e9800998ecf8427e", "/blobs/{hex}"},
+		{"/sessions/dGhpcyBpcyBhIHRva2VuMTIz==", "/sessions/{token}"},
+		{"/account_settings_page_version", "/account_settings_page_version"},
+	} {
+		if got := NormalizePath(c.path); got != c.expected {
+			t.Errorf("NormalizePath(%q): expected %q, but got %q", c.path, c.expected, got)
+		}
+	}
+}
+
+func TestAccessProf_Report_normalizePaths(t *testing.T) {
+	logs := append(makeLogs("GET", "/users/1", 200, 0), makeLogs("GET", "/users/2", 200, 0)...)
+	report := buildReport(logs, nil, reportOptions{normalizePaths: true})
+	if len(report.Segments) != 1 || report.Segments[0].Path != "/users/{id}" {
+		t.Fatalf("expected a single segment GET /users/{id}, but got %v", report)
+	}
+}
+
+func TestReport_SuggestAggregates(t *testing.T) {
+	var logs []*AccessLog
+	for i := 0; i < 10; i++ {
+		logs = append(logs, makeLogs("GET", fmt.Sprintf("/users/%d/posts", i), 200, 0)...)
+		logs = append(logs, makeLogs("GET", fmt.Sprintf("/tags/tag%d", i), 200, 0)...)
+	}
+	logs = append(logs, makeLogs("GET", "/about", 200, 0)...)
+
+	suggestions := buildReport(logs, nil, reportOptions{}).SuggestAggregates(DefaultSuggestionCardinality)
+	expected := []string{`/tags/[^/]+`, `/users/\d+/posts`}
+	if !reflect.DeepEqual(suggestions, expected) {
+		t.Fatalf("expected %q, but got %q", expected, suggestions)
+	}
+}
+
+func TestReport_SuggestAggregates_normalizedPaths(t *testing.T) {
+	var logs []*AccessLog
+	for i := 0; i < 10; i++ {
+		logs = append(logs, makeLogs("GET", fmt.Sprintf("/items/%d/tag%d", i, i), 200, 0)...)
+	}
+
+	suggestions := buildReport(logs, nil, reportOptions{normalizePaths: true}).SuggestAggregates(DefaultSuggestionCardinality)
+	if len(suggestions) != 1 {
+		t.Fatalf("expected a single suggestion, but got %q", suggestions)
+	}
+	if re := regexp.MustCompile("^" + suggestions[0] + "$"); !re.MatchString("/items/3/tag3") {
+		t.Fatalf("expected the suggestion %q to match raw paths, but it doesn't match /items/3/tag3", suggestions[0])
+	}
+}
+
+func TestSplitAggregates(t *testing.T) {
+	var logs []*AccessLog
+	for i := 0; i < 10; i++ {
+		logs = append(logs, makeLogs("GET", fmt.Sprintf("/a,b/%d", i), 200, 0)...)
+	}
+	suggestions := buildReport(logs, nil, reportOptions{}).SuggestAggregates(DefaultSuggestionCardinality)
+
+	aggs := splitAggregates(strings.Join(append(suggestions, `/v\d{1,2}/[,a]+`), ","))
+	expected := []string{`/a\,b/\d+`, `/v\d{1,2}/[,a]+`}
+	if !reflect.DeepEqual(aggs, expected) {
+		t.Fatalf("expected %q, but got %q", expected, aggs)
+	}
+}
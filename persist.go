@@ -0,0 +1,155 @@
+package accessprof
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// reportFormatVersion is the version of the format written by Report.Save.
+// Bump it when the format changes incompatibly.
+const reportFormatVersion = 1
+
+type savedReport struct {
+	Version    int             `json:"version"`
+	Since      time.Time       `json:"since"`
+	Until      time.Time       `json:"until"`
+	Aggregates []string        `json:"aggregates,omitempty"`
+	Segments   []*savedSegment `json:"segments"`
+	Overflowed int             `json:"overflowed,omitempty"`
+	SLO        *SLO            `json:"slo,omitempty"`
+}
+
+type savedSegment struct {
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	PathRegexp      string            `json:"path_regexp,omitempty"`
+	Status          int               `json:"status"`
+	GRPCCode        string            `json:"grpc_code,omitempty"`
+	Outbound        bool              `json:"outbound,omitempty"`
+	Host            string            `json:"host,omitempty"`
+	Count           int               `json:"count"`
+	MinResponseTime time.Duration     `json:"min_response_time_nano"`
+	MaxResponseTime time.Duration     `json:"max_response_time_nano"`
+	SumResponseTime time.Duration     `json:"sum_response_time_nano"`
+	MinBody         int               `json:"min_response_body_size"`
+	MaxBody         int               `json:"max_response_body_size"`
+	SumBody         int               `json:"sum_response_body_size"`
+	Latency         *latencySketch    `json:"latency"`
+	Instances       map[string]int    `json:"instances,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	MaxConcurrency  int               `json:"max_concurrency,omitempty"`
+	// Histogram is written for consumers of the JSON; LoadReport restores it from Latency
+	Histogram []HistogramBucket `json:"histogram,omitempty"`
+}
+
+// Save writes the report to w as JSON.
+// Only the statistics of segments are saved; raw access logs are not.
+func (r *Report) Save(w io.Writer) error {
+	saved := savedReport{Version: reportFormatVersion, Since: r.Since, Until: r.Until, Overflowed: r.Overflowed, SLO: r.slo}
+	for _, agg := range r.Aggregates {
+		saved.Aggregates = append(saved.Aggregates, agg.String())
+	}
+	for _, seg := range r.Segments {
+		seg.ensureStats()
+		s := &savedSegment{
+			Method:          seg.Method,
+			Path:            seg.Path,
+			Status:          seg.Status,
+			GRPCCode:        seg.GRPCCode,
+			Outbound:        seg.Outbound,
+			Host:            seg.Host,
+			Count:           seg.count,
+			MinResponseTime: seg.minResponseTime,
+			MaxResponseTime: seg.maxResponseTime,
+			SumResponseTime: seg.sumResponseTime,
+			MinBody:         seg.minBody,
+			MaxBody:         seg.maxBody,
+			SumBody:         seg.sumBody,
+			Latency:         &seg.latency,
+			Histogram:       seg.Histogram(),
+			Instances:       seg.Instances,
+			Headers:         seg.Headers,
+			MaxConcurrency:  seg.maxInFlight,
+		}
+		if seg.PathRegexp != nil {
+			s.PathRegexp = seg.PathRegexp.String()
+		}
+		saved.Segments = append(saved.Segments, s)
+	}
+	return errors.Wrap(json.NewEncoder(w).Encode(saved), "failed to save report")
+}
+
+// LoadReport reads a report written by Report.Save.
+func LoadReport(r io.Reader) (*Report, error) {
+	var saved savedReport
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, errors.Wrap(err, "failed to load report")
+	}
+	if saved.Version != reportFormatVersion {
+		return nil, errors.Errorf("unsupported report format version %d", saved.Version)
+	}
+	report := &Report{Since: saved.Since, Until: saved.Until, Overflowed: saved.Overflowed}
+	regexps := map[string]*regexp.Regexp{}
+	compile := func(s string) (*regexp.Regexp, error) {
+		if re, ok := regexps[s]; ok {
+			return re, nil
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to compile regexp %q", s)
+		}
+		regexps[s] = re
+		return re, nil
+	}
+	for _, agg := range saved.Aggregates {
+		re, err := compile(agg)
+		if err != nil {
+			return nil, err
+		}
+		report.Aggregates = append(report.Aggregates, re)
+	}
+	for _, s := range saved.Segments {
+		if s.Count <= 0 {
+			return nil, errors.Errorf("segment %s %s has invalid count %d", s.Method, s.Path, s.Count)
+		}
+		if s.Latency != nil && s.Latency.count != int64(s.Count) {
+			return nil, errors.Errorf("segment %s %s has %d requests, but its latency sketch has %d", s.Method, s.Path, s.Count, s.Latency.count)
+		}
+		seg := &ReportSegment{
+			Method:          s.Method,
+			Path:            s.Path,
+			Status:          s.Status,
+			GRPCCode:        s.GRPCCode,
+			Outbound:        s.Outbound,
+			Host:            s.Host,
+			count:           s.Count,
+			minResponseTime: s.MinResponseTime,
+			maxResponseTime: s.MaxResponseTime,
+			sumResponseTime: s.SumResponseTime,
+			minBody:         s.MinBody,
+			maxBody:         s.MaxBody,
+			sumBody:         s.SumBody,
+			Instances:       s.Instances,
+			Headers:         s.Headers,
+			maxInFlight:     s.MaxConcurrency,
+		}
+		if s.Latency != nil {
+			seg.latency = *s.Latency
+		}
+		if s.PathRegexp != "" {
+			re, err := compile(s.PathRegexp)
+			if err != nil {
+				return nil, err
+			}
+			seg.PathRegexp = re
+		}
+		report.Segments = append(report.Segments, seg)
+	}
+	report.setWindow()
+	report.SetSLO(saved.SLO)
+	return report, nil
+}
@@ -2,12 +2,12 @@ package accessprof
 
 import (
 	"bytes"
+	"fmt"
+	"html/template"
 	"io"
-	"math"
 	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/olekukonko/tablewriter"
@@ -18,21 +18,88 @@ type ReportSegment struct {
 	Path       string
 	PathRegexp *regexp.Regexp
 	Status     int
+	// Outbound is true for segments of outbound requests (see AccessProf.WrapTransport)
+	Outbound bool
+	// Host is the destination host of outbound requests
+	Host string
+	// AccessLogs holds the raw logs of the segment (empty for reports loaded by LoadReport).
+	// The statistics of segments built by buildReport, LoadReport and MergeReports are kept separately;
+	// they are computed from AccessLogs only for segments built by callers, e.g. ReportSegment{AccessLogs: logs}.
 	AccessLogs []*AccessLog
+	// GRPCCode is the gRPC status code of RPCs of the segment (empty for HTTP requests; see AccessLog.GRPCCode)
+	GRPCCode string
+	// Headers are the values of AccessProf.GroupByHeaders which the segment is grouped by (nil if not grouped)
+	Headers map[string]string
+	// Instances is the number of requests by instance for reports merged by MergeInstances
+	// or built from logs shipped by Shipper (nil otherwise)
+	Instances map[string]int
+
+	count           int
+	minResponseTime time.Duration
+	maxResponseTime time.Duration
+	sumResponseTime time.Duration
+	minBody         int
+	maxBody         int
+	sumBody         int
+	latency         latencySketch
+	maxInFlight     int
+	// window is the time window of the report (see Report.setWindow)
+	window time.Duration
+	// slo is the SLO of the report (see Report.SetSLO)
+	slo *SLO
 }
 
-func (seg *ReportSegment) match(l *AccessLog) bool {
-	if seg.Method != l.Method || seg.Status != l.Status {
-		return false
+func (seg *ReportSegment) add(l *AccessLog) {
+	seg.AccessLogs = append(seg.AccessLogs, l)
+	seg.addStats(l)
+}
+
+// addStats adds l to the statistics of seg without keeping l.
+func (seg *ReportSegment) addStats(l *AccessLog) {
+	if seg.count == 0 || seg.minResponseTime > l.ResponseTime {
+		seg.minResponseTime = l.ResponseTime
 	}
-	if seg.PathRegexp != nil {
-		return seg.PathRegexp.MatchString(l.Path)
+	if seg.maxResponseTime < l.ResponseTime {
+		seg.maxResponseTime = l.ResponseTime
+	}
+	if seg.count == 0 || seg.minBody > l.ResponseBodySize {
+		seg.minBody = l.ResponseBodySize
 	}
-	return seg.Path == l.Path
+	if seg.maxBody < l.ResponseBodySize {
+		seg.maxBody = l.ResponseBodySize
+	}
+	seg.sumResponseTime += l.ResponseTime
+	seg.sumBody += l.ResponseBodySize
+	seg.latency.add(l.ResponseTime)
+	if seg.maxInFlight < l.InFlight {
+		seg.maxInFlight = l.InFlight
+	}
+	if l.Instance != "" {
+		if seg.Instances == nil {
+			seg.Instances = map[string]int{}
+		}
+		seg.Instances[l.Instance]++
+	}
+	seg.count++
 }
 
-func (seg *ReportSegment) add(l *AccessLog) {
-	seg.AccessLogs = append(seg.AccessLogs, l)
+// ensureStats computes the statistics of a segment built by callers from its AccessLogs,
+// as the statistics used to be computed from AccessLogs alone.
+func (seg *ReportSegment) ensureStats() {
+	if seg.count != 0 || len(seg.AccessLogs) == 0 {
+		return
+	}
+	for _, l := range seg.AccessLogs {
+		seg.addStats(l)
+	}
+}
+
+// statusText formats the status of the segment, followed by the gRPC status code of RPCs (e.g. "500 Internal").
+func (seg *ReportSegment) statusText() string {
+	if seg.GRPCCode != "" {
+		return strconv.Itoa(seg.Status) + " " + seg.GRPCCode
+	}
+	return strconv.Itoa(seg.Status)
 }
 
 func (seg *ReportSegment) AggregationPath() string {
@@ -49,71 +116,79 @@ func (seg *ReportSegment) AggregationPath() string {
 	return seg.Path
 }
 
+// key identifies segments with the same status, method, aggregation path, destination and grouping headers.
+func (seg *ReportSegment) key() string {
+	key := seg.statusText() + " " + seg.Method + " " + seg.AggregationPath()
+	if seg.Outbound {
+		key = "outbound " + seg.Host + " " + key
+	}
+	if seg.Headers != nil {
+		key += " " + seg.headersText()
+	}
+	return key
+}
+
 func (seg *ReportSegment) Count() int {
-	return len(seg.AccessLogs)
+	seg.ensureStats()
+	return seg.count
 }
 
 func (seg *ReportSegment) MinResponseTime() time.Duration {
-	var n time.Duration = math.MaxInt32
-	for _, l := range seg.AccessLogs {
-		if n > l.ResponseTime {
-			n = l.ResponseTime
-		}
-	}
-	return n
+	seg.ensureStats()
+	return seg.minResponseTime
 }
 
 func (seg *ReportSegment) MaxResponseTime() time.Duration {
-	var n time.Duration
-	for _, l := range seg.AccessLogs {
-		if n < l.ResponseTime {
-			n = l.ResponseTime
-		}
-	}
-	return n
+	seg.ensureStats()
+	return seg.maxResponseTime
 }
 
 func (seg *ReportSegment) SumResponseTime() time.Duration {
-	var n time.Duration
-	for _, l := range seg.AccessLogs {
-		n += l.ResponseTime
-	}
-	return n
+	seg.ensureStats()
+	return seg.sumResponseTime
 }
 
+// AvgResponseTime returns the average response time (0 if the segment has no requests).
 func (seg *ReportSegment) AvgResponseTime() time.Duration {
+	if seg.Count() == 0 {
+		return 0
+	}
 	return seg.SumResponseTime() / time.Duration(seg.Count())
 }
 
-func (seg *ReportSegment) MinBody() int {
-	var n int = math.MaxInt32
-	for _, l := range seg.AccessLogs {
-		if n > l.ResponseBodySize {
-			n = l.ResponseBodySize
-		}
+// Percentile returns the approximate p-th percentile (0 <= p <= 100) of response times.
+func (seg *ReportSegment) Percentile(p float64) time.Duration {
+	seg.ensureStats()
+	d := seg.latency.quantile(p / 100)
+	if d < seg.minResponseTime {
+		return seg.minResponseTime
 	}
-	return n
+	if d > seg.maxResponseTime {
+		return seg.maxResponseTime
+	}
+	return d
+}
+
+func (seg *ReportSegment) MinBody() int {
+	seg.ensureStats()
+	return seg.minBody
 }
 
 func (seg *ReportSegment) MaxBody() int {
-	var n int
-	for _, l := range seg.AccessLogs {
-		if n < l.ResponseBodySize {
-			n = l.ResponseBodySize
-		}
-	}
-	return n
+	seg.ensureStats()
+	return seg.maxBody
 }
 
 func (seg *ReportSegment) SumBody() int {
-	var n int
-	for _, l := range seg.AccessLogs {
-		n += l.ResponseBodySize
-	}
-	return n
+	seg.ensureStats()
+	return seg.sumBody
 }
 
+// AvgBody returns the average response body size (0 if the segment has no requests).
 func (seg *ReportSegment) AvgBody() float64 {
+	if seg.Count() == 0 {
+		return 0
+	}
 	return float64(seg.SumBody()) / float64(seg.Count())
 }
 
@@ -121,6 +196,34 @@ type Report struct {
 	Segments   []*ReportSegment
 	Aggregates []*regexp.Regexp
 	Since      time.Time
+	// Until is the time when the last request ended
+	Until time.Time
+	// slo is the latency targets of the Apdex and SLO columns (nil if not set; see SetSLO)
+	slo *SLO
+	// Overflowed is the number of logs put into overflow segments because of cardinality limits
+	Overflowed int
+}
+
+// InboundSegments returns segments of requests served by Handler.
+func (r *Report) InboundSegments() []*ReportSegment {
+	var segs []*ReportSegment
+	for _, seg := range r.Segments {
+		if !seg.Outbound {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// OutboundSegments returns segments of requests sent by transports wrapped by AccessProf.WrapTransport.
+func (r *Report) OutboundSegments() []*ReportSegment {
+	var segs []*ReportSegment
+	for _, seg := range r.Segments {
+		if seg.Outbound {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
 }
 
 func (r *Report) RequestCount() int {
@@ -133,87 +236,469 @@ func (r *Report) RequestCount() int {
 
 func (r *Report) String() string {
 	var buf bytes.Buffer
-	w := tablewriter.NewWriter(&buf)
-	w.SetHeader([]string{"STATUS", "METHOD", "PATH", "COUNT", "MIN", "MAX", "SUM", "AVG", "MIN(BODY)", "MAX(BODY)", "SUM(BODY)", "AVG(BODY)"})
-	for _, seg := range r.Segments {
-		w.Append([]string{
-			strconv.Itoa(seg.Status),
-			seg.Method,
-			seg.AggregationPath(),
-			strconv.Itoa(seg.Count()),
-			seg.MinResponseTime().String(),
-			seg.MaxResponseTime().String(),
-			seg.SumResponseTime().String(),
-			seg.AvgResponseTime().String(),
-			strconv.Itoa(seg.MinBody()),
-			strconv.Itoa(seg.MaxBody()),
-			strconv.Itoa(seg.SumBody()),
-			strconv.FormatFloat(seg.AvgBody(), 'f', 3, 64),
-		})
-	}
-	w.Render()
+	r.RenderText(&buf, nil)
 	return buf.String()
 }
 
-func (r *Report) RenderHTML(w io.Writer, reportPath string) error {
-	data := struct {
-		Header       []string
-		RequestCount int
-		Rows         [][]string
-		ReportPath   string
-		Aggregates   string
-		Since        string
-	}{}
+// TextOptions controls the table rendered by Report.RenderText.
+type TextOptions struct {
+	// SortBy is the column key to sort segments by (insertion order if empty). "body" is an alias of "sum_body".
+	SortBy string
+	// Ascending sorts segments in ascending order instead of descending order
+	Ascending bool
+	// Limit is the maximum number of rows (unlimited if 0)
+	Limit int
+	// Columns is the list of column keys to render (DefaultColumns if empty)
+	Columns []string
+	// HumanUnits formats durations and body sizes in human-friendly units
+	HumanUnits bool
+	// Summary renders the status breakdown of all requests before the table,
+	// and a "SUMMARY" table of status classes and error rates for each method and path after the tables
+	Summary bool
+}
+
+// RenderText renders the report as a text table. opts may be nil for the default options.
+// Outbound segments are rendered in a separate table following an "OUTBOUND" line.
+func (r *Report) RenderText(w io.Writer, opts *TextOptions) error {
+	if opts == nil {
+		opts = &TextOptions{}
+	}
+	cs, err := lookupColumns(opts.Columns)
+	if err != nil {
+		return err
+	}
+	if opts.Summary {
+		summary := r.StatusSummary()
+		if _, err := fmt.Fprintf(w, "REQUESTS: %d (%s)\n%s\n", summary.Count, summary.breakdown(), r.throughput()); err != nil {
+			return err
+		}
+		if s := r.sloSummary(); s != "" {
+			if _, err := fmt.Fprintln(w, s); err != nil {
+				return err
+			}
+		}
+	}
+	if err := renderTextTable(w, r.InboundSegments(), cs, opts); err != nil {
+		return err
+	}
+
+	if outbound := r.OutboundSegments(); len(outbound) != 0 {
+		if cs, err = lookupOutboundColumns(opts.Columns); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\nOUTBOUND\n"); err != nil {
+			return err
+		}
+		if err := renderTextTable(w, outbound, cs, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.Summary {
+		if _, err := io.WriteString(w, "\nSUMMARY\n"); err != nil {
+			return err
+		}
+		table := tablewriter.NewWriter(w)
+		table.SetHeader(summaryHeader)
+		for _, s := range r.StatusSummaries() {
+			table.Append(s.cells())
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func renderTextTable(w io.Writer, segs []*ReportSegment, cs []*column, opts *TextOptions) error {
+	if opts.SortBy != "" {
+		by, err := lookupColumn(opts.SortBy)
+		if err != nil {
+			return err
+		}
+		segs = sortSegments(segs, by, opts.Ascending)
+	}
+	if opts.Limit > 0 && len(segs) > opts.Limit {
+		segs = segs[:opts.Limit]
+	}
+
+	table := tablewriter.NewWriter(w)
+	header := make([]string, len(cs))
+	for i, c := range cs {
+		header[i] = c.header
+	}
+	table.SetHeader(header)
+	for _, seg := range segs {
+		row := make([]string, len(cs))
+		for i, c := range cs {
+			row[i] = c.text(seg, opts.HumanUnits)
+		}
+		table.Append(row)
+	}
+	table.Render()
+	return nil
+}
+
+// htmlPage is the data passed to the HTML templates.
+// URLs in the pages are relative unless ReportPath is given, so that they work wherever the report is mounted.
+type htmlPage struct {
+	// ReportPath is the path of the report which the page links to (relative to the page if empty)
+	ReportPath string
+	// CSRFToken is sent with requests to reset logs and cut snapshots
+	CSRFToken string
+	// Filters describes the rules of AccessProf.Filter
+	Filters []string
+	// Snapshot is the name of the snapshot being shown (empty for the current window)
+	Snapshot  string
+	Snapshots []*Snapshot
+	// Live is true if the page updates its tables by the live report stream
+	Live bool
+	// AggregationOnly hides the controls except the aggregation box (see HTMLOptions)
+	AggregationOnly bool
+	// Histograms are the latency histograms of the rows, keyed by the table id
+	Histograms map[string][][]HistogramBucket
+	// Slowest are the slowest requests of the rows shown with the histograms, keyed by the table id
+	Slowest map[string][][]*slowLog
+	// StatusBreakdown is the status breakdown of all requests
+	StatusBreakdown string
+	// Throughput is the throughput and concurrency of all requests
+	Throughput string
+	// SLOSummary is the SLO compliance of all requests (empty if the report has no SLO)
+	SLOSummary string
+	// SummaryRows are the status classes and error rates for each method and path (see summaryHeader)
+	SummaryHeader []string
+	SummaryRows   [][]string
+
+	Header       []string
+	RequestCount int
+	Rows         [][]string
+	// OutboundHeader and OutboundRows are the table of outbound segments (empty if there are none)
+	OutboundHeader []string
+	OutboundRows   [][]string
+	Aggregates     string
+	Since          string
+	// SuggestedAggregates is a comma-separated list of regexps proposed by Report.SuggestAggregates (see splitAggregates)
+	SuggestedAggregates string
+	Overflowed          int
+}
+
+func (r *Report) renderHTML(w io.Writer, data *htmlPage) error {
 	data.Header = []string{"STATUS", "METHOD", "PATH", "COUNT", "MIN", "MAX", "SUM", "AVG", "MIN(BODY)", "MAX(BODY)", "SUM(BODY)", "AVG(BODY)"}
+	data.Header = append(append(data.Header, r.htmlExtras().header()...), "LATENCY")
 	data.RequestCount = r.RequestCount()
-	data.ReportPath = reportPath
+	data.Rows, data.OutboundRows = r.htmlRows()
+	data.Histograms = r.htmlHistograms()
+	data.Slowest = r.htmlSlowest()
+	data.StatusBreakdown = r.StatusSummary().breakdown()
+	data.Throughput = r.throughput()
+	data.SLOSummary = r.sloSummary()
+	data.SummaryHeader = summaryHeader
+	for _, s := range r.StatusSummaries() {
+		data.SummaryRows = append(data.SummaryRows, s.cells())
+	}
+	if len(data.OutboundRows) != 0 {
+		data.OutboundHeader = append([]string{"HOST"}, data.Header...)
+	}
+	data.Aggregates = r.aggregatesParam()
+	data.SuggestedAggregates = strings.Join(r.SuggestAggregates(DefaultSuggestionCardinality), ",")
+	data.Since = r.Since.Format(time.RFC3339Nano)
+	data.Overflowed = r.Overflowed
+	return tmpl.ExecuteTemplate(w, "report", data)
+}
+
+// htmlRows returns the rows of the inbound and outbound tables of the HTML report.
+func (r *Report) htmlRows() (rows, outboundRows [][]string) {
+	rows, outboundRows = [][]string{}, [][]string{}
+	extras := r.htmlExtras()
+	for _, seg := range r.InboundSegments() {
+		rows = append(rows, htmlRow(seg, extras))
+	}
+	for _, seg := range r.OutboundSegments() {
+		outboundRows = append(outboundRows, append([]string{seg.Host}, htmlRow(seg, extras)...))
+	}
+	return rows, outboundRows
+}
+
+// htmlExtras are the optional columns of the HTML report, inserted before the histogram.
+type htmlExtras struct {
+	// slo adds the Apdex and SLO columns
+	slo bool
+	// instances adds the breakdown by instance
+	instances bool
+	// headers adds the grouping headers
+	headers bool
+}
+
+func (r *Report) htmlExtras() htmlExtras {
+	x := htmlExtras{slo: r.slo != nil, instances: r.hasInstances()}
 	for _, seg := range r.Segments {
-		data.Rows = append(data.Rows, []string{
-			strconv.Itoa(seg.Status),
-			seg.Method,
-			seg.AggregationPath(),
-			strconv.Itoa(seg.Count()),
-			stringifyDuration(seg.MinResponseTime()),
-			stringifyDuration(seg.MaxResponseTime()),
-			stringifyDuration(seg.SumResponseTime()),
-			stringifyDuration(seg.AvgResponseTime()),
-			strconv.Itoa(seg.MinBody()),
-			strconv.Itoa(seg.MaxBody()),
-			strconv.Itoa(seg.SumBody()),
-			strconv.FormatFloat(seg.AvgBody(), 'f', 3, 64),
-		})
-	}
-	if len(r.Aggregates) != 0 {
-		aggs := make([]string, len(r.Aggregates))
-		for i, agg := range r.Aggregates {
-			aggs[i] = agg.String()
+		x.headers = x.headers || seg.Headers != nil
+	}
+	return x
+}
+
+// slowLogsPerRow is the number of the slowest requests shown for each row of the HTML report.
+const slowLogsPerRow = 5
+
+// slowLog is a request shown in the drill-down of a row.
+type slowLog struct {
+	Path         string `json:"path"`
+	ResponseTime string `json:"response_time"`
+	AccessedAt   string `json:"accessed_at"`
+	Headers      string `json:"headers"`
+}
+
+// htmlSlowest returns the slowest requests of the rows returned by htmlRows, keyed by the table id.
+func (r *Report) htmlSlowest() map[string][][]*slowLog {
+	slowest := func(segs []*ReportSegment) [][]*slowLog {
+		rows := make([][]*slowLog, len(segs))
+		for i, seg := range segs {
+			rows[i] = []*slowLog{}
+			for _, l := range seg.SlowestLogs(slowLogsPerRow) {
+				headers := map[string]string{}
+				for name, v := range l.RequestHeaders {
+					headers[requestHeaderPrefix+name] = v
+				}
+				for name, v := range l.ResponseHeaders {
+					headers[responseHeaderPrefix+name] = v
+				}
+				rows[i] = append(rows[i], &slowLog{
+					Path:         l.Path,
+					ResponseTime: stringifyDuration(l.ResponseTime),
+					AccessedAt:   l.AccessedAt.Format(time.RFC3339Nano),
+					Headers:      formatHeaders(headers),
+				})
+			}
 		}
-		data.Aggregates = strings.Join(aggs, ",")
+		return rows
 	}
-	data.Since = r.Since.Format(time.RFC3339Nano)
+	return map[string][][]*slowLog{
+		"profile-table":  slowest(r.InboundSegments()),
+		"outbound-table": slowest(r.OutboundSegments()),
+	}
+}
+
+func (x htmlExtras) header() []string {
+	var header []string
+	if x.headers {
+		header = append(header, "HEADERS")
+	}
+	if x.slo {
+		header = append(header, "APDEX", "WITHIN TARGET", "ERROR BUDGET")
+	}
+	if x.instances {
+		header = append(header, "INSTANCES")
+	}
+	return header
+}
 
-	return tmpl.Execute(w, data)
+func (x htmlExtras) cells(seg *ReportSegment) []string {
+	var cells []string
+	if x.headers {
+		cells = append(cells, seg.headersText())
+	}
+	if x.slo {
+		for _, key := range []string{"apdex", "within_target", "error_budget"} {
+			c, _ := lookupColumn(key)
+			cells = append(cells, c.text(seg, false))
+		}
+	}
+	if x.instances {
+		cells = append(cells, seg.instancesText())
+	}
+	return cells
 }
 
-var tmpl = template.Must(template.New("accessprof").Parse(`<!DOCTYPE html>
-<html lang="ja">
+// htmlHistograms returns the latency histograms of the rows returned by htmlRows, keyed by the table id.
+func (r *Report) htmlHistograms() map[string][][]HistogramBucket {
+	histograms := func(segs []*ReportSegment) [][]HistogramBucket {
+		hs := make([][]HistogramBucket, len(segs))
+		for i, seg := range segs {
+			hs[i] = seg.Histogram()
+		}
+		return hs
+	}
+	return map[string][][]HistogramBucket{
+		"profile-table":  histograms(r.InboundSegments()),
+		"outbound-table": histograms(r.OutboundSegments()),
+	}
+}
+
+// htmlRow returns the cells of a row.
+func htmlRow(seg *ReportSegment, extras htmlExtras) []string {
+	row := []string{
+		seg.statusText(),
+		seg.Method,
+		seg.AggregationPath(),
+		strconv.Itoa(seg.Count()),
+		stringifyDuration(seg.MinResponseTime()),
+		stringifyDuration(seg.MaxResponseTime()),
+		stringifyDuration(seg.SumResponseTime()),
+		stringifyDuration(seg.AvgResponseTime()),
+		strconv.Itoa(seg.MinBody()),
+		strconv.Itoa(seg.MaxBody()),
+		strconv.Itoa(seg.SumBody()),
+		strconv.FormatFloat(seg.AvgBody(), 'f', 3, 64),
+	}
+	row = append(row, extras.cells(seg)...)
+	return append(row, sparkline(seg.Histogram()))
+}
+
+func (r *Report) aggregatesParam() string {
+	if len(r.Aggregates) == 0 {
+		return ""
+	}
+	aggs := make([]string, len(r.Aggregates))
+	for i, agg := range r.Aggregates {
+		aggs[i] = agg.String()
+	}
+	return strings.Join(aggs, ",")
+}
+
+// RenderHTML renders the report as an HTML page.
+// The page links to reportPath, or to itself by relative URLs if reportPath is empty.
+func (r *Report) RenderHTML(w io.Writer, reportPath string) error {
+	return r.RenderHTMLWithOptions(w, &HTMLOptions{ReportPath: reportPath})
+}
+
+// HTMLOptions controls the HTML page rendered by Report.RenderHTMLWithOptions.
+type HTMLOptions struct {
+	// ReportPath is the path of the report which the page links to (relative to the page if empty)
+	ReportPath string
+	// AggregationOnly hides the controls which need ReportHandler (snapshots, live updates, exports and reset),
+	// and keeps only the aggregation box. It is for pages served by other handlers which take the agg parameter,
+	// e.g. the merged report of accessprof collect.
+	AggregationOnly bool
+}
+
+// RenderHTMLWithOptions renders the report as an HTML page controlled by opts.
+func (r *Report) RenderHTMLWithOptions(w io.Writer, opts *HTMLOptions) error {
+	return r.renderHTML(w, &htmlPage{ReportPath: opts.ReportPath, AggregationOnly: opts.AggregationOnly})
+}
+
+// RenderHTML renders the comparison as an HTML page.
+// The page links to reportPath, or to itself by relative URLs if reportPath is empty.
+func (c *Comparison) RenderHTML(w io.Writer, reportPath string) error {
+	return c.renderHTML(w, &htmlPage{ReportPath: reportPath})
+}
+
+func (c *Comparison) renderHTML(w io.Writer, data *htmlPage) error {
+	data.Header = []string{"STATUS", "METHOD", "PATH", "COUNT(BASE)", "COUNT(TARGET)", "AVG(BASE)", "AVG(TARGET)", "AVG(DIFF)", "MAX(BASE)", "MAX(TARGET)", "AVG(BODY,BASE)", "AVG(BODY,TARGET)"}
+	data.RequestCount = c.Target.RequestCount()
+	data.Aggregates = c.Target.aggregatesParam()
+	data.Since = c.Target.Since.Format(time.RFC3339Nano)
+	for _, row := range c.Rows {
+		status := strconv.Itoa(row.Status)
+		if row.GRPCCode != "" {
+			status += " " + row.GRPCCode
+		}
+		cols := []string{status, row.Method, row.Host + row.Path}
+		for _, seg := range []*ReportSegment{row.Base, row.Target} {
+			if seg == nil {
+				cols = append(cols, "0")
+			} else {
+				cols = append(cols, strconv.Itoa(seg.Count()))
+			}
+		}
+		cols = append(cols, comparedDurations(row, (*ReportSegment).AvgResponseTime)...)
+		if change, ok := row.AvgResponseTimeChange(); ok {
+			cols = append(cols, strconv.FormatFloat(change*100, 'f', 1, 64)+"%")
+		} else {
+			cols = append(cols, "-")
+		}
+		cols = append(cols, comparedDurations(row, (*ReportSegment).MaxResponseTime)...)
+		for _, seg := range []*ReportSegment{row.Base, row.Target} {
+			if seg == nil {
+				cols = append(cols, "-")
+			} else {
+				cols = append(cols, strconv.FormatFloat(seg.AvgBody(), 'f', 3, 64))
+			}
+		}
+		data.Rows = append(data.Rows, cols)
+	}
+	return tmpl.ExecuteTemplate(w, "comparison", data)
+}
+
+func comparedDurations(row *ComparisonRow, f func(*ReportSegment) time.Duration) []string {
+	var cols []string
+	for _, seg := range []*ReportSegment{row.Base, row.Target} {
+		if seg == nil {
+			cols = append(cols, "-")
+		} else {
+			cols = append(cols, stringifyDuration(f(seg)))
+		}
+	}
+	return cols
+}
+
+var tmpl = template.Must(template.New("accessprof").Parse(`
+{{ define "head" }}
   <head>
     <meta charset="UTF-8">
+    <meta name="csrf-token" content="{{ .CSRFToken }}">
     <link rel="stylesheet" type="text/css" href="https://cdn.datatables.net/v/bs-3.3.7/jq-3.2.1/dt-1.10.16/datatables.min.css"/>
     <script type="text/javascript" src="https://cdn.datatables.net/v/bs-3.3.7/jq-3.2.1/dt-1.10.16/datatables.min.js"></script>
     <script type="text/javascript" src="//cdn.datatables.net/plug-ins/1.10.16/sorting/numeric-comma.js"></script>
     <script>
       $(document).ready(function() {
+        var histograms = {{ .Histograms }} || {};
+        var slowest = {{ .Slowest }} || {};
         $("#profile-table").DataTable({
           columnDefs: [
-            { type: 'numeric-comma', targets: [3, 4, 5 ,6, 7, 8, 9, 10, 11] }
+            { type: 'numeric-comma', targets: [3, 4, 5 ,6, 7, 8, 9, 10, 11] },
+            { className: 'histogram', targets: histograms["profile-table"] ? [-1] : [] }
+          ]
+        });
+        $("#outbound-table").DataTable({
+          columnDefs: [
+            { type: 'numeric-comma', targets: [4, 5 ,6, 7, 8, 9, 10, 11, 12] },
+            { className: 'histogram', targets: [-1] }
           ]
         });
 
+        function formatNanos(nanos) {
+          return (nanos / 1000000).toFixed(3) + "ms";
+        }
+        function slowestTable(logs) {
+          var table = $('<table class="table table-condensed"><thead><tr><th>SLOWEST</th><th>RESPONSE TIME</th><th>ACCESSED AT</th><th>HEADERS</th></tr></thead></table>');
+          $.each(logs, function(_, l) {
+            var tr = $("<tr></tr>").appendTo(table);
+            $.each([l.path, l.response_time, l.accessed_at, l.headers], function(_, cell) { $("<td></td>").text(cell).appendTo(tr); });
+          });
+          return table;
+        }
+        function histogramTable(buckets) {
+          var max = 0;
+          $.each(buckets, function(_, b) { max = Math.max(max, b.count); });
+          var table = $('<table class="table table-condensed"></table>');
+          $.each(buckets, function(_, b) {
+            var range = formatNanos(b.min_nano) + " - " + (b.max_nano ? formatNanos(b.max_nano) : "");
+            var bar = $('<div></div>').css({ background: "#337ab7", height: "12px", width: (max ? 100 * b.count / max : 0) + "%" });
+            table.append($("<tr></tr>")
+              .append($("<td></td>").text(range))
+              .append($('<td style="width: 60%"></td>').append(bar))
+              .append($("<td></td>").text(b.count)));
+          });
+          return table;
+        }
+        $("#profile-table, #outbound-table").on("click", "td.histogram", function() {
+          var id = $(this).closest("table").attr("id");
+          var row = $("#" + id).DataTable().row($(this).closest("tr"));
+          if (row.child.isShown()) {
+            row.child.hide();
+          } else {
+            var details = $("<div></div>").append(histogramTable((histograms[id] || [])[row.index()] || []));
+            var logs = (slowest[id] || [])[row.index()] || [];
+            if (logs.length) {
+              details.append(slowestTable(logs));
+            }
+            row.child(details).show();
+          }
+        });
+
         $("#reset-button").on("click", function() {
           $.ajax({
-              url: "{{ .ReportPath }}",
+              url: {{ .ReportPath }} || location.pathname,
               type: "DELETE",
+              headers: { "X-CSRF-Token": $('meta[name="csrf-token"]').attr("content") },
           })
           .done(function(data) {
               alert('OK');
@@ -223,13 +708,52 @@ var tmpl = template.Must(template.New("accessprof").Parse(`<!DOCTYPE html>
               alert('Failed to reset logs');
           });
         });
+
+        $("#snapshot-form").on("submit", function(e) {
+          e.preventDefault();
+          $.ajax({
+              url: {{ .ReportPath }} || location.pathname,
+              type: "POST",
+              headers: { "X-CSRF-Token": $('meta[name="csrf-token"]').attr("content") },
+              data: $(this).serialize(),
+          })
+          .done(function(data) {
+              location.reload();
+          })
+          .fail(function(xhr) {
+              alert('Failed to cut a snapshot: ' + xhr.responseText);
+          });
+        });
+
+        {{ if .Live }}
+          var source = new EventSource({{ .ReportPath }} + "?format=events&agg=" + encodeURIComponent({{ .Aggregates }}));
+          source.addEventListener("report", function(e) {
+            var data = JSON.parse(e.data);
+            histograms = data.histograms;
+            slowest = data.slowest;
+            $("#request-count").text(data.request_count);
+            $("#status-breakdown").text(data.status_breakdown);
+            $("#throughput").text(data.throughput);
+            $("#slo-summary").text(data.slo_summary);
+            var summary = $("#summary-table tbody").empty();
+            $.each(data.summary_rows || [], function(_, cells) {
+              var tr = $("<tr></tr>").appendTo(summary);
+              $.each(cells, function(_, cell) { $("<td></td>").text(cell).appendTo(tr); });
+            });
+            $("#profile-table").DataTable().clear().rows.add(data.rows).draw(false);
+            if ($("#outbound-table").length) {
+              $("#outbound-table").DataTable().clear().rows.add(data.outbound_rows).draw(false);
+            }
+          });
+        {{ end }}
       });
     </script>
+    <style>td.histogram { cursor: pointer; }</style>
     <title></title>
   </head>
-  <body>
-    <div>
-      <p>Get {{ .RequestCount }} requests (Since {{ .Since }})</p>
+{{ end }}
+
+{{ define "table" }}
       <table id="profile-table" class="table table-bordered">
         <thead>
           <tr>
@@ -248,21 +772,150 @@ var tmpl = template.Must(template.New("accessprof").Parse(`<!DOCTYPE html>
           {{ end }}
         </tbody>
       </table>
+{{ end }}
+
+{{ define "snapshots" }}
+      <div class="row">
+        <div class="col-sm-5">
+          <form id="snapshot-form">
+            <input type="text" name="name" placeholder="snapshot name">
+            <input type="submit" value="Cut snapshot">
+          </form>
+          <ul>
+            <li><a href="{{ $.ReportPath }}?">current</a></li>
+            {{ range .Snapshots }}
+              <li><a href="{{ $.ReportPath }}?snapshot={{ .Name }}">{{ .Name }}</a> ({{ .Count }} requests)</li>
+            {{ end }}
+          </ul>
+        </div>
+        <div class="col-sm-7">
+          <form action="{{ $.ReportPath }}" method="get">
+            <select name="base">
+              {{ range .Snapshots }}<option value="{{ .Name }}">{{ .Name }}</option>{{ end }}
+            </select>
+            <select name="snapshot">
+              <option value="">current</option>
+              {{ range .Snapshots }}<option value="{{ .Name }}">{{ .Name }}</option>{{ end }}
+            </select>
+            <input type="hidden" name="agg" value="{{ .Aggregates }}">
+            <input type="submit" value="Compare">
+          </form>
+        </div>
+      </div>
+{{ end }}
+
+{{ define "report" }}<!DOCTYPE html>
+<html lang="ja">
+  {{ template "head" . }}
+  <body>
+    <div>
+      <p>{{ if .Snapshot }}Snapshot {{ .Snapshot }}: {{ end }}Get <span id="request-count">{{ .RequestCount }}</span> requests (Since {{ .Since }})</p>
+      {{ if .StatusBreakdown }}<p id="status-breakdown">{{ .StatusBreakdown }}</p>{{ end }}
+      {{ if .Throughput }}<p id="throughput">{{ .Throughput }}</p>{{ end }}
+      {{ if .SLOSummary }}<p id="slo-summary">{{ .SLOSummary }}</p>{{ end }}
+      {{ if .Filters }}
+        <p>Filtered: {{ range $i, $f := .Filters }}{{ if $i }}, {{ end }}{{ $f }}{{ end }}</p>
+      {{ end }}
+      {{ if .Overflowed }}
+        <div class="alert alert-warning">
+          Cardinality limit was hit: {{ .Overflowed }} requests are reported as OTHER or (other).
+          Consider adding aggregates.
+        </div>
+      {{ end }}
+      {{ template "table" . }}
+      {{ if .OutboundRows }}
+        <h4>Outbound</h4>
+        <table id="outbound-table" class="table table-bordered">
+          <thead>
+            <tr>
+              {{ range .OutboundHeader }}
+                <th>{{.}}</th>
+              {{ end }}
+            </tr>
+          </thead>
+          <tbody>
+            {{ range .OutboundRows }}
+              <tr>
+                {{ range . }}
+                  <td>{{ . }}</td>
+                {{end}}
+              </tr>
+            {{ end }}
+          </tbody>
+        </table>
+      {{ end }}
+      {{ if .SummaryRows }}
+        <h4>Summary</h4>
+        <table id="summary-table" class="table table-bordered table-condensed">
+          <thead>
+            <tr>
+              {{ range .SummaryHeader }}
+                <th>{{.}}</th>
+              {{ end }}
+            </tr>
+          </thead>
+          <tbody>
+            {{ range .SummaryRows }}
+              <tr>
+                {{ range . }}
+                  <td>{{ . }}</td>
+                {{end}}
+              </tr>
+            {{ end }}
+          </tbody>
+        </table>
+      {{ end }}
       <div class="row">
         <div class="col-sm-5">
-          <form action="{{ .ReportPath }}" method="get">
+          <form action="{{ $.ReportPath }}" method="get">
             <input type="text" name="agg" placeholder="/users/\d+,/.*\.png" value="{{ .Aggregates }}">
+            {{ if .Snapshot }}<input type="hidden" name="snapshot" value="{{ .Snapshot }}">{{ end }}
             <input type="submit" value="Go">
           </form>
+          {{ if .SuggestedAggregates }}
+            <p>Suggested: <a href="{{ $.ReportPath }}?agg={{ .SuggestedAggregates }}">{{ .SuggestedAggregates }}</a></p>
+          {{ end }}
         </div>
+        {{ if not .AggregationOnly }}
         <div class="col=sm-7">
+          <a href="{{ $.ReportPath }}?format=csv&amp;agg={{ .Aggregates }}&amp;snapshot={{ .Snapshot }}" class="btn btn-default">CSV</a>
+          <a href="{{ $.ReportPath }}?format=tsv&amp;agg={{ .Aggregates }}&amp;snapshot={{ .Snapshot }}" class="btn btn-default">TSV</a>
+          {{ if not .Snapshot }}
+            {{ if .Live }}
+              <a href="{{ $.ReportPath }}?agg={{ .Aggregates }}" class="btn btn-default">Stop live</a>
+            {{ else }}
+              <a href="{{ $.ReportPath }}?live=1&amp;agg={{ .Aggregates }}" class="btn btn-default">Live</a>
+            {{ end }}
+          {{ end }}
           <a href="#" id="reset-button" class="btn btn-danger">Reset</a>
         </div>
+        {{ end }}
       </div>
+      {{ if not .AggregationOnly }}{{ template "snapshots" . }}{{ end }}
+    </div>
   </body>
 </html>
+{{ end }}
+
+{{ define "comparison" }}<!DOCTYPE html>
+<html lang="ja">
+  {{ template "head" . }}
+  <body>
+    <div>
+      <p><a href="{{ $.ReportPath }}?">Back</a></p>
+      {{ template "table" . }}
+      {{ template "snapshots" . }}
+    </div>
+  </body>
+</html>
+{{ end }}
 `))
 
+// FormatDuration formats d in milliseconds as the reports do, e.g. "1.500ms".
+func FormatDuration(d time.Duration) string {
+	return stringifyDuration(d)
+}
+
 func stringifyDuration(d time.Duration) string {
 	nanos := d.Nanoseconds()
 	return strconv.FormatFloat(float64(nanos)/1000000, 'f', 3, 64) + "ms"
@@ -0,0 +1,492 @@
+package accessprof
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport_Save(t *testing.T) {
+	var a AccessProf
+	server := httptest.NewServer(a.Wrap(testHandler, ""))
+	defer server.Close()
+
+	http.Get(server.URL)
+	http.Get(server.URL + "/test/1")
+	http.Get(server.URL + "/test/2")
+
+	report := a.Report([]*regexp.Regexp{regexp.MustCompile(`^/test/\d+$`)})
+	var buf bytes.Buffer
+	if err := report.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.String() != loaded.String() {
+		t.Fatalf("loaded report differs from the saved one:\n%s\n%s", report, loaded)
+	}
+	if !loaded.Since.Equal(report.Since) {
+		t.Errorf("expected since %v, but got %v", report.Since, loaded.Since)
+	}
+	if p := loaded.Segments[1].Percentile(95); p != report.Segments[1].Percentile(95) {
+		t.Errorf("latency sketch is not restored: expected p95 %v, but got %v", report.Segments[1].Percentile(95), p)
+	}
+}
+
+func TestLoadReport_unsupportedVersion(t *testing.T) {
+	if _, err := LoadReport(bytes.NewBufferString(`{"version": 999}`)); err == nil {
+		t.Fatal("LoadReport should reject unknown format versions")
+	}
+}
+
+func TestLoadReport_invalidCount(t *testing.T) {
+	for _, s := range []string{
+		`{"version": 1, "segments": [{"method": "GET", "path": "/", "count": 0}]}`,
+		`{"version": 1, "segments": [{"method": "GET", "path": "/", "count": -1}]}`,
+		`{"version": 1, "segments": [{"method": "GET", "path": "/", "count": 2, "latency": {"zero": 0, "bins": {"100": 1}}}]}`,
+		`{"version": 1, "segments": [{"method": "GET", "path": "/", "count": 1, "latency": {"zero": 2, "bins": {"100": -1}}}]}`,
+	} {
+		if _, err := LoadReport(bytes.NewBufferString(s)); err == nil {
+			t.Errorf("LoadReport should reject segments with invalid counts, but loaded %s", s)
+		}
+	}
+}
+
+func TestReportSegment_Percentile(t *testing.T) {
+	seg := new(ReportSegment)
+	for i := 1; i <= 100; i++ {
+		seg.add(&AccessLog{ResponseTime: time.Duration(i) * time.Millisecond})
+	}
+	for _, c := range []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{95, 95 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	} {
+		got := seg.Percentile(c.p)
+		if diff := float64(got-c.expected) / float64(c.expected); diff > sketchRelativeAccuracy || diff < -sketchRelativeAccuracy {
+			t.Errorf("expected p%v to be about %v, but got %v", c.p, c.expected, got)
+		}
+	}
+}
+
+func TestReportSegment_Percentile_fewRequests(t *testing.T) {
+	fast := make([]time.Duration, 49)
+	for i := range fast {
+		fast[i] = time.Millisecond
+	}
+	for _, c := range []struct {
+		name     string
+		logs     []*AccessLog
+		p        float64
+		expected time.Duration
+	}{
+		{"p99 of 2", makeLogs("GET", "/", 200, 10*time.Millisecond, time.Second), 99, time.Second},
+		{"p50 of 2", makeLogs("GET", "/", 200, 10*time.Millisecond, time.Second), 50, 10 * time.Millisecond},
+		{"p99 of 50", append(makeLogs("GET", "/", 200, fast...), makeLogs("GET", "/", 200, time.Second)...), 99, time.Second},
+		{"p0 of 1", makeLogs("GET", "/", 200, time.Second), 0, time.Second},
+	} {
+		seg := new(ReportSegment)
+		for _, l := range c.logs {
+			seg.add(l)
+		}
+		got := seg.Percentile(c.p)
+		if diff := float64(got-c.expected) / float64(c.expected); diff > sketchRelativeAccuracy || diff < -sketchRelativeAccuracy {
+			t.Errorf("%s: expected p%v to be about %v, but got %v", c.name, c.p, c.expected, got)
+		}
+	}
+}
+
+func TestReportSegment_builtByCallers(t *testing.T) {
+	seg := &ReportSegment{Method: "GET", Path: "/users", Status: 200, AccessLogs: []*AccessLog{
+		{ResponseTime: time.Millisecond, ResponseBodySize: 10},
+		{ResponseTime: 3 * time.Millisecond, ResponseBodySize: 30},
+	}}
+	if seg.Count() != 2 || seg.AvgResponseTime() != 2*time.Millisecond || seg.MaxBody() != 30 || seg.AvgBody() != 20 {
+		t.Fatalf("expected the statistics of AccessLogs, but got count %d, avg %v, max body %d", seg.Count(), seg.AvgResponseTime(), seg.MaxBody())
+	}
+
+	empty := new(ReportSegment)
+	if empty.AvgResponseTime() != 0 || empty.AvgBody() != 0 {
+		t.Fatalf("expected zero averages of an empty segment, but got %v and %v", empty.AvgResponseTime(), empty.AvgBody())
+	}
+}
+
+func TestBuildReport_cardinalityLimits(t *testing.T) {
+	var logs []*AccessLog
+	for i := 0; i < 10; i++ {
+		logs = append(logs, makeLogs("GET", fmt.Sprintf("/users/%d", i), 200, 0)...)
+	}
+	logs = append(logs, makeLogs("FUZZ", "/users/0", 200, 0)...)
+
+	report := buildReport(logs, nil, reportOptions{maxPaths: 3, maxMethods: 1})
+	if len(report.Segments) != 5 {
+		t.Fatalf("expected 5 segments, 3 paths, GET (other) and OTHER /users/0; but got %d", len(report.Segments))
+	}
+	if report.Overflowed != 8 {
+		t.Errorf("expected 8 overflowed logs, but got %d", report.Overflowed)
+	}
+
+	report = buildReport(logs, nil, reportOptions{maxSegments: 4})
+	if len(report.Segments) != 5 || report.Segments[4].Path != OtherPath || report.Segments[4].Count() != 7 {
+		t.Fatalf("expected 4 segments and an overflow segment with 7 logs, but got %v", report)
+	}
+	if report.Overflowed != 7 {
+		t.Errorf("expected 7 overflowed logs, but got %d", report.Overflowed)
+	}
+	if overflow := report.Segments[4]; overflow.Status != 200 || len(overflow.AccessLogs) != 0 {
+		t.Errorf("expected the overflow segment to keep the status 200 and no raw logs, but got status %d and %d logs", overflow.Status, len(overflow.AccessLogs))
+	}
+}
+
+func TestAccessProf_Record_cardinalityLimits(t *testing.T) {
+	a := AccessProf{MaxPaths: 3, MaxSegments: 4}
+	for i := 0; i < 10; i++ {
+		for _, l := range makeLogs("GET", fmt.Sprintf("/users/%d", i), 200, 0) {
+			a.Record(l)
+		}
+	}
+	for _, l := range makeLogs("POST", "/users/0", 500, 0) {
+		a.Record(l)
+	}
+	for _, l := range makeLogs("PUT", "/users/0", 500, 0) {
+		a.Record(l)
+	}
+
+	paths := map[string]bool{}
+	for _, l := range a.accessLogs {
+		paths[l.Path] = true
+	}
+	if len(paths) != 4 {
+		t.Fatalf("expected logs in memory to hold 3 paths and %s, but got %v", OtherPath, paths)
+	}
+	report := a.Report(nil)
+	if report.Overflowed != 9 {
+		t.Errorf("expected 9 overflowed logs, but got %d", report.Overflowed)
+	}
+	last := report.Segments[len(report.Segments)-1]
+	if last.Method != OtherMethod || last.Path != OtherPath || last.Status != 500 || last.Count() != 2 {
+		t.Errorf("expected POST and PUT /users/0 to be in the overflow segment of status 500, but got %d logs in %s %s %d", last.Count(), last.Method, last.Path, last.Status)
+	}
+}
+
+func TestReport_RenderText(t *testing.T) {
+	logs := append(append(
+		makeLogs("GET", "/a", 200, time.Millisecond),
+		makeLogs("GET", "/b", 200, 2*time.Millisecond, 2*time.Millisecond, 2*time.Millisecond)...),
+		makeLogs("GET", "/c", 200, 3*time.Millisecond, 3*time.Millisecond)...,
+	)
+	report := buildReport(logs, nil, reportOptions{})
+
+	var buf bytes.Buffer
+	err := report.RenderText(&buf, &TextOptions{SortBy: "count", Limit: 2, Columns: []string{"path", "count", "p99"}, HumanUnits: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `+------+-------+--------+
+| PATH | COUNT |  P99   |
++------+-------+--------+
+| /b   |     3 | 2.00ms |
+| /c   |     2 | 3.00ms |
++------+-------+--------+
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+
+	if err := report.RenderText(&buf, &TextOptions{SortBy: "unknown"}); err == nil {
+		t.Fatal("RenderText should reject unknown sort keys")
+	}
+}
+
+func TestReportSegment_SlowestLogs(t *testing.T) {
+	logs := makeLogs("GET", "/users", 200, 3*time.Millisecond, time.Millisecond, 5*time.Millisecond, 3*time.Millisecond, 2*time.Millisecond)
+	seg := buildReport(logs, nil, reportOptions{}).Segments[0]
+
+	slowest := seg.SlowestLogs(3)
+	if len(slowest) != 3 || slowest[0] != logs[2] || slowest[1] != logs[0] || slowest[2] != logs[3] {
+		t.Fatalf("expected the 3 slowest logs in descending order of response time, but got %v", slowest)
+	}
+	if n := len(seg.SlowestLogs(10)); n != len(logs) {
+		t.Fatalf("expected all %d logs, but got %d", len(logs), n)
+	}
+	if n := len(seg.SlowestLogs(0)); n != 0 {
+		t.Fatalf("expected no logs, but got %d", n)
+	}
+}
+
+func TestReportSegment_Histogram(t *testing.T) {
+	report := buildReport(makeLogs("GET", "/users", 200, 1500*time.Microsecond, 1500*time.Microsecond, 1500*time.Microsecond, 150*time.Millisecond), nil, reportOptions{})
+	h := report.Segments[0].Histogram()
+	if len(h) != 7 {
+		t.Fatalf("expected 7 buckets from [1ms, 2ms) to [100ms, 200ms), but got %v", h)
+	}
+	if h[0].Min != time.Millisecond || h[0].Count != 3 {
+		t.Errorf("expected 3 responses in [1ms, 2ms), but got %v", h[0])
+	}
+	if h[6].Max != 200*time.Millisecond || h[6].Count != 1 {
+		t.Errorf("expected 1 response in [100ms, 200ms), but got %v", h[6])
+	}
+	if s := sparkline(h); s != "█▁▁▁▁▁▄" {
+		t.Errorf("unexpected sparkline %q", s)
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	var logs []*AccessLog
+	for i := 1; i <= 100; i++ {
+		logs = append(logs, makeLogs("GET", "/users", 200, time.Duration(i)*time.Millisecond)...)
+	}
+	whole := buildReport(logs, nil, reportOptions{})
+	a := buildReport(append(logs[:50:50], makeLogs("POST", "/users", 201, time.Millisecond)...), nil, reportOptions{})
+
+	// round-trip b through JSON, as reports of other processes are
+	var buf bytes.Buffer
+	if err := buildReport(logs[50:], nil, reportOptions{}).Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := MergeReports(a, b)
+	if len(merged.Segments) != 2 {
+		t.Fatalf("expected 2 segments, GET /users and POST /users; but got %d", len(merged.Segments))
+	}
+	seg := merged.Segments[0]
+	if seg.Count() != 100 || seg.MinResponseTime() != time.Millisecond || seg.MaxResponseTime() != 100*time.Millisecond {
+		t.Fatalf("unexpected merged segment: count %d, min %v, max %v", seg.Count(), seg.MinResponseTime(), seg.MaxResponseTime())
+	}
+	for _, p := range []float64{50, 90, 99} {
+		if seg.Percentile(p) != whole.Segments[0].Percentile(p) {
+			t.Errorf("expected merged p%v to equal %v, but got %v", p, whole.Segments[0].Percentile(p), seg.Percentile(p))
+		}
+	}
+}
+
+func TestMergeInstances(t *testing.T) {
+	merged := MergeInstances(map[string]*Report{
+		"app1": buildReport(makeLogs("GET", "/users", 200, time.Millisecond, time.Millisecond), nil, reportOptions{}),
+		"app2": buildReport(makeLogs("GET", "/users", 200, time.Millisecond), nil, reportOptions{}),
+	})
+	if len(merged.Segments) != 1 {
+		t.Fatalf("expected 1 segment, but got %d", len(merged.Segments))
+	}
+	if s := merged.Segments[0].instancesText(); s != "app1=2 app2=1" {
+		t.Fatalf("unexpected breakdown by instance %q", s)
+	}
+	var buf bytes.Buffer
+	if err := merged.RenderHTML(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("app1=2 app2=1")) {
+		t.Fatal("the HTML report should have the breakdown by instance")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`id="reset-button"`)) {
+		t.Fatal("RenderHTML should keep the controls of the report")
+	}
+}
+
+func TestReport_renderHTML_reportPath(t *testing.T) {
+	report := buildReport(makeLogs("GET", "/users", 200, time.Millisecond), nil, reportOptions{})
+	for _, c := range []struct {
+		reportPath string
+		expected   string
+	}{
+		{"", `href="?format=csv`},
+		{"/accessprof", `href="/accessprof?format=csv`},
+	} {
+		var buf bytes.Buffer
+		if err := report.renderHTML(&buf, &htmlPage{ReportPath: c.reportPath}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), c.expected) {
+			t.Errorf("expected the page to link by %s with reportPath %q", c.expected, c.reportPath)
+		}
+	}
+}
+
+func TestReport_StatusSummaries(t *testing.T) {
+	logs := append(append(append(append(
+		makeLogs("GET", "/users", 200, 0, 0, 0, 0, 0, 0, 0),
+		makeLogs("GET", "/users", 404, 0)...),
+		makeLogs("GET", "/users", 500, 0, 0)...),
+		makeLogs("GET", "/items", 200, 0)...),
+		makeLogs("GET", "/items", 101, 0)...,
+	)
+	report := buildReport(logs, nil, reportOptions{})
+
+	summaries := report.StatusSummaries()
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 summaries, GET /users and GET /items; but got %d", len(summaries))
+	}
+	s := summaries[0]
+	if s.Count != 10 || s.ByClass[2] != 7 || s.ByClass[4] != 1 || s.ByClass[5] != 2 || s.ErrorRate() != 0.2 {
+		t.Fatalf("unexpected summary of GET /users: %+v", s)
+	}
+	if s := summaries[1]; s.Count != 2 || s.Other() != 1 || s.cells()[7] != "1" {
+		t.Fatalf("expected the 101 response of GET /items in the other column, but got %v", s.cells())
+	}
+	if b := report.StatusSummary().breakdown(); b != "2xx: 8, 3xx: 0, 4xx: 1, 5xx: 2, other: 1, errors: 16.67%" {
+		t.Fatalf("unexpected status breakdown %q", b)
+	}
+
+	var buf bytes.Buffer
+	if err := report.RenderText(&buf, &TextOptions{Summary: true, Columns: []string{"path"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "REQUESTS: 12 (2xx: 8,") || !strings.Contains(buf.String(), "\nSUMMARY\n") {
+		t.Fatalf("expected the status breakdown and the summary table, but got\n%s", buf.String())
+	}
+}
+
+func TestReport_throughput(t *testing.T) {
+	since := time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC)
+	logs := []*AccessLog{
+		{Method: "GET", Path: "/users", Status: 200, AccessedAt: since, ResponseTime: time.Second, InFlight: 1},
+		{Method: "GET", Path: "/users", Status: 200, AccessedAt: since.Add(time.Second), ResponseTime: 2 * time.Second, InFlight: 1},
+		{Method: "GET", Path: "/items", Status: 200, AccessedAt: since.Add(2 * time.Second), ResponseTime: 2 * time.Second, InFlight: 2},
+	}
+	report := buildReport(logs, nil, reportOptions{})
+
+	if d := report.Duration(); d != 4*time.Second {
+		t.Fatalf("expected the window to be 4s, but got %v", d)
+	}
+	if rps := report.RequestsPerSecond(); rps != 0.75 {
+		t.Errorf("expected 0.75 req/s, but got %v", rps)
+	}
+	if rps := report.Segments[0].RequestsPerSecond(); rps != 0.5 {
+		t.Errorf("expected 0.5 req/s of GET /users, but got %v", rps)
+	}
+	if c := report.MaxConcurrency(); c != 2 {
+		t.Errorf("expected max concurrency 2, but got %d", c)
+	}
+	if c := report.AvgConcurrency(); c != 1.25 {
+		t.Errorf("expected average concurrency 1.25, but got %v", c)
+	}
+	if s := report.throughput(); !strings.Contains(s, "concurrency max 2, avg 1.25") {
+		t.Errorf("unexpected throughput %q", s)
+	}
+	var untrackedLogs []*AccessLog
+	for _, l := range logs {
+		untrackedLog := *l
+		untrackedLog.InFlight = 0
+		untrackedLogs = append(untrackedLogs, &untrackedLog)
+	}
+	untracked := buildReport(untrackedLogs, nil, reportOptions{})
+	if s := untracked.throughput(); !strings.Contains(s, "(concurrency avg 1.25)") {
+		t.Errorf("expected the unknown max concurrency to be omitted, but got %q", s)
+	}
+	if col, _ := lookupColumn("max_concurrency"); col.text(untracked.Segments[0], false) != "-" {
+		t.Errorf("expected the unknown max concurrency to be -, but got %q", col.text(untracked.Segments[0], false))
+	}
+
+	var buf bytes.Buffer
+	if err := report.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Segments[1].RequestsPerSecond() != 0.25 || loaded.MaxConcurrency() != 2 {
+		t.Errorf("throughput is not restored: %v req/s, max concurrency %d", loaded.Segments[1].RequestsPerSecond(), loaded.MaxConcurrency())
+	}
+}
+
+func TestReport_SLO(t *testing.T) {
+	logs := append(append(append(
+		makeLogs("GET", "/users", 200, 50*time.Millisecond, 50*time.Millisecond, 150*time.Millisecond, time.Second),
+		makeLogs("GET", "/users", 500, 50*time.Millisecond)...),
+		makeLogs("GET", "/search", 200, 150*time.Millisecond)...),
+		makeLogs("GET", "/search", 200, time.Second)...,
+	)
+	report := buildReport(logs, nil, reportOptions{slo: &SLO{
+		Target:      100 * time.Millisecond,
+		PathTargets: map[string]time.Duration{"/search": 500 * time.Millisecond},
+		Objective:   0.5,
+	}})
+
+	users := report.Segments[0]
+	// 2 satisfied, 1 tolerating and 1 frustrated
+	if a := users.Apdex(); a != 0.625 {
+		t.Errorf("expected Apdex 0.625 of GET /users, but got %v", a)
+	}
+	if w := users.WithinTarget(); w != 0.5 {
+		t.Errorf("expected 50%% of GET /users within the target, but got %v", w)
+	}
+	// 2 bad requests of 2 allowed
+	if b := users.ErrorBudget(); b != 0 {
+		t.Errorf("expected no error budget of GET /users remaining, but got %v", b)
+	}
+	if a := report.Segments[2].Apdex(); a != 0.75 {
+		t.Errorf("expected Apdex 0.75 of GET /search with the overridden target, but got %v", a)
+	}
+	// all requests of 5xx segments are bad
+	if b := report.Segments[1].ErrorBudget(); b != -1 {
+		t.Errorf("expected the error budget of GET /users 500 to be exhausted (-100%%), but got %v", b)
+	}
+
+	noSLO := buildReport(logs, nil, reportOptions{})
+	var buf bytes.Buffer
+	if err := noSLO.RenderText(&buf, &TextOptions{Columns: []string{"path", "apdex"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "| /users  | -     |") {
+		t.Errorf("the apdex column should be empty without SLO, but got\n%s", buf.String())
+	}
+
+	noSLO.SetSLO(report.SLO())
+	if a := noSLO.Segments[0].Apdex(); a != 0.625 || noSLO.Apdex() != report.Apdex() {
+		t.Errorf("expected the same Apdex as the report built with SLO, but got %v", a)
+	}
+}
+
+func TestReport_GRPCCode(t *testing.T) {
+	var buf bytes.Buffer
+	for _, code := range []string{"Internal", "DataLoss", "Internal"} {
+		l := &AccessLog{Method: "GRPC", Path: "/pkg.Service/Method", Status: 500, GRPCCode: code}
+		if err := l.writeLTSV(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var logs []*AccessLog
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		l, err := parseLTSV(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, l)
+	}
+	report := buildReport(logs, nil, reportOptions{})
+	if len(report.Segments) != 2 || report.Segments[0].GRPCCode != "Internal" || report.Segments[1].GRPCCode != "DataLoss" {
+		t.Fatalf("expected segments split by gRPC codes, but got %d segments", len(report.Segments))
+	}
+	if s := htmlRow(report.Segments[1], htmlExtras{})[0]; s != "500 DataLoss" {
+		t.Errorf("expected the status cell to show the gRPC code, but got %q", s)
+	}
+
+	buf.Reset()
+	if err := report.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Segments[1].GRPCCode != "DataLoss" {
+		t.Errorf("gRPC code is not restored: %q", loaded.Segments[1].GRPCCode)
+	}
+}
@@ -0,0 +1,189 @@
+package accessprof
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// ReportHandler returns an http.Handler serving the HTML report.
+// Unlike Handler, it serves the report for any request path, and the page links to itself by relative URLs,
+// so it can be mounted on an admin-only listener or under a prefix (e.g. with http.StripPrefix).
+//
+// GET shows the report, POST cuts a snapshot and DELETE resets the access logs (or deletes the snapshot
+// named by the snapshot query parameter).
+// The format query parameter selects json (Report.Save), csv, tsv, or events, which streams the current report
+// as Server-Sent Events every interval seconds (DefaultStreamInterval by default). With live=1, the HTML report
+// subscribes to the stream and updates its tables in place.
+// Requests are authorized by AccessProf.Authorize and AccessProf.AuthorizeReset.
+func (a *AccessProf) ReportHandler() http.Handler {
+	return &reportHandler{AccessProf: a}
+}
+
+type reportHandler struct {
+	*AccessProf
+}
+
+func (a *reportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, r.Method == http.MethodDelete) {
+		return
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		if name := r.URL.Query().Get("snapshot"); name != "" {
+			a.serveDeleteSnapshotRequest(w, name)
+			return
+		}
+		a.Reset()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		a.serveReportRequest(w, r)
+	case http.MethodPost:
+		a.serveSnapshotRequest(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *reportHandler) serveReportRequest(w http.ResponseWriter, r *http.Request) {
+	if err := r.Body.Close(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var aggs []*regexp.Regexp
+	for _, aggparam := range r.URL.Query()["agg"] {
+		for _, agg := range splitAggregates(aggparam) {
+			re, err := regexp.Compile("^" + agg + "$")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				body, _ := json.Marshal(map[string]string{
+					"error": fmt.Sprintf("failed to compile regexp %q: %v", re, err),
+				})
+				w.Write(body)
+				return
+			}
+			aggs = append(aggs, re)
+		}
+	}
+	query := r.URL.Query()
+	if query.Get("format") == "events" {
+		a.serveEvents(w, r, aggs)
+		return
+	}
+	report, err := a.snapshotReport(query.Get("snapshot"), aggs)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	switch query.Get("format") {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		report.Save(w)
+		return
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="accessprof.csv"`)
+		report.WriteCSV(w)
+		return
+	case "tsv":
+		w.Header().Set("Content-Type", "text/tab-separated-values")
+		w.Header().Set("Content-Disposition", `attachment; filename="accessprof.tsv"`)
+		report.WriteTSV(w)
+		return
+	}
+	page := &htmlPage{CSRFToken: a.CSRFToken(), Filters: a.Filter.Rules(), Snapshot: query.Get("snapshot"), Snapshots: a.Snapshots()}
+	page.Live = page.Snapshot == "" && query.Get("live") != ""
+	if query.Get("base") != "" {
+		base, err := a.snapshotReport(query.Get("base"), aggs)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		err = CompareReports(base, report).renderHTML(w, page)
+	} else {
+		err = report.renderHTML(w, page)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// splitAggregates splits the comma-separated agg parameter into regexps.
+// Commas which are escaped or inside brackets or braces (e.g. `\d{2,4}`) belong to the regexps.
+func splitAggregates(s string) []string {
+	var (
+		aggs    []string
+		start   int
+		braces  int
+		class   bool
+		escaped bool
+	)
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case class:
+			class = c != ']'
+		case c == '[':
+			class = true
+		case c == '{':
+			braces++
+		case c == '}' && braces > 0:
+			braces--
+		case c == ',' && braces == 0:
+			if i > start {
+				aggs = append(aggs, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		aggs = append(aggs, s[start:])
+	}
+	return aggs
+}
+
+// snapshotReport makes a report of the snapshot named name, or of the current window if name is empty.
+func (a *reportHandler) snapshotReport(name string, aggs []*regexp.Regexp) (*Report, error) {
+	if name == "" {
+		return a.Report(aggs), nil
+	}
+	s := a.Snapshot(name)
+	if s == nil {
+		return nil, errors.Errorf("snapshot %q is not found", name)
+	}
+	return s.Report(aggs), nil
+}
+
+func (a *reportHandler) serveSnapshotRequest(w http.ResponseWriter, r *http.Request) {
+	s, err := a.Cut(r.FormValue("name"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(s.Name))
+}
+
+func (a *reportHandler) serveDeleteSnapshotRequest(w http.ResponseWriter, name string) {
+	if a.Snapshot(name) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := a.DeleteSnapshot(name); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,163 @@
+package accessprof
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Shipper sends access logs to a collector (see CollectorHandler) as LTSV over HTTP.
+// Set it to AccessProf.Shipper to ship logs whenever they are flushed.
+type Shipper struct {
+	// URL is the endpoint of the collector, e.g. "http://collector:8090/logs".
+	// "unix:/path/to/socket" sends logs to a collector listening on the Unix domain socket.
+	URL string
+	// Instance labels shipped logs, so that the collector can break down its report by instance
+	Instance string
+	// Client sends logs (a client with DefaultShipTimeout, dialing the socket for unix: URLs, if nil)
+	Client *http.Client
+	// MaxRetryLogs limits the number of logs kept in memory to retry shipping while the collector is down
+	// (DefaultMaxRetryLogs if 0). The oldest logs beyond the limit are dropped, and counted by Dropped.
+	MaxRetryLogs int
+
+	once     sync.Once
+	client   *http.Client
+	endpoint string
+	dropped  int64
+}
+
+const (
+	// DefaultShipTimeout is the timeout of requests to the collector sent by Shipper without Client.
+	DefaultShipTimeout = 10 * time.Second
+	// DefaultMaxRetryLogs is the default of Shipper.MaxRetryLogs.
+	DefaultMaxRetryLogs = 10000
+	// maxCollectBodySize limits requests to CollectorHandler.
+	maxCollectBodySize = 64 << 20
+)
+
+// errRejected is returned by Ship if the collector rejects the logs, which are not worth retrying.
+var errRejected = errors.New("access logs are rejected by the collector")
+
+func (s *Shipper) init() {
+	s.client, s.endpoint = s.Client, s.URL
+	if strings.HasPrefix(s.URL, "unix:") {
+		socket := strings.TrimPrefix(s.URL, "unix:")
+		if s.client == nil {
+			s.client = &http.Client{Timeout: DefaultShipTimeout, Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			}}
+		}
+		// the host is ignored by the dialer
+		s.endpoint = "http://unix/"
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: DefaultShipTimeout}
+	}
+}
+
+// Dropped returns the number of logs dropped because the collector rejected them,
+// or because they exceeded MaxRetryLogs while the collector was down.
+func (s *Shipper) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// retryLogs returns the logs to ship again after Ship(logs) failed with err.
+// Rejected logs are dropped, since the collector would reject them again.
+func (s *Shipper) retryLogs(logs []*AccessLog, err error) []*AccessLog {
+	if err == nil {
+		return nil
+	}
+	if errors.Cause(err) == errRejected {
+		atomic.AddInt64(&s.dropped, int64(len(logs)))
+		return nil
+	}
+	max := s.MaxRetryLogs
+	if max <= 0 {
+		max = DefaultMaxRetryLogs
+	}
+	if len(logs) > max {
+		atomic.AddInt64(&s.dropped, int64(len(logs)-max))
+		logs = logs[len(logs)-max:]
+	}
+	return logs
+}
+
+// Ship sends logs to the collector in one request.
+func (s *Shipper) Ship(logs []*AccessLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	s.once.Do(s.init)
+	var buf bytes.Buffer
+	for _, l := range logs {
+		if s.Instance != "" && l.Instance == "" {
+			shipped := *l
+			shipped.Instance = s.Instance
+			l = &shipped
+		}
+		if err := l.writeLTSV(&buf); err != nil {
+			return err
+		}
+	}
+	res, err := s.client.Post(s.endpoint, "text/plain", &buf)
+	if err != nil {
+		return errors.Wrap(err, "failed to ship access logs")
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusOK:
+		return nil
+	case res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests:
+		return errors.Wrap(errRejected, res.Status)
+	default:
+		return errors.Errorf("failed to ship access logs: %s", res.Status)
+	}
+}
+
+// CollectorHandler returns an http.Handler receiving logs sent by Shipper, and recording them in a.
+// Serve the report of the collected logs by ReportHandler. Requests larger than 64MB are rejected.
+func (a *AccessProf) CollectorHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if a.Authorize != nil && !a.Authorize(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var logs []*AccessLog
+		s := bufio.NewScanner(http.MaxBytesReader(w, r.Body, maxCollectBodySize))
+		line := 0
+		for s.Scan() {
+			line++
+			l, err := parseLTSV(s.Text())
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(errors.Wrapf(err, "failed to parse log at line %d", line).Error()))
+				return
+			}
+			logs = append(logs, l)
+		}
+		if err := s.Err(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		for _, l := range logs {
+			a.Record(l)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
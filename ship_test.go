@@ -0,0 +1,175 @@
+package accessprof
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShipper_http(t *testing.T) {
+	var collector AccessProf
+	server := httptest.NewServer(collector.CollectorHandler())
+	defer server.Close()
+
+	a := &AccessProf{Shipper: &Shipper{URL: server.URL, Instance: "app1"}}
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, ResponseTime: time.Millisecond, AccessedAt: time.Now()})
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, ResponseTime: time.Millisecond, AccessedAt: time.Now()})
+	if err := a.flushLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Count() != 0 {
+		t.Fatalf("shipped logs should be removed, but %d logs remain", a.Count())
+	}
+	report := collector.Report(nil)
+	if len(report.Segments) != 1 || report.Segments[0].Count() != 2 {
+		t.Fatalf("expected the collector to have 2 logs of GET /users, but got %v", report)
+	}
+	if n := report.Segments[0].Instances["app1"]; n != 2 {
+		t.Fatalf("expected 2 logs labelled app1, but got %d", n)
+	}
+}
+
+func TestShipper_unixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accessprof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "collector.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var collector AccessProf
+	server := &http.Server{Handler: collector.CollectorHandler()}
+	go server.Serve(l)
+	defer l.Close()
+
+	s := &Shipper{URL: "unix:" + socket, Instance: "app1"}
+	if err := s.Ship([]*AccessLog{{Method: "GET", Path: "/users", Status: 200, AccessedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	if collector.Count() != 1 {
+		t.Fatalf("expected the collector to have 1 log, but got %d", collector.Count())
+	}
+}
+
+func TestShipper_keepsLogsOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	a := &AccessProf{Shipper: &Shipper{URL: server.URL}}
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, AccessedAt: time.Now()})
+	if err := a.flushLogs(); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected an error of 503, but got %v", err)
+	}
+	if a.Count() != 1 {
+		t.Fatalf("logs failed to be shipped should be kept, but got %d logs", a.Count())
+	}
+}
+
+func TestShipper_escapesPaths(t *testing.T) {
+	var collector AccessProf
+	server := httptest.NewServer(collector.CollectorHandler())
+	defer server.Close()
+
+	s := &Shipper{URL: server.URL}
+	if err := s.Ship([]*AccessLog{{Method: "GET", Path: "/a\nb\t\\c", Status: 200, AccessedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	report := collector.Report(nil)
+	if len(report.Segments) != 1 || report.Segments[0].Path != "/a\nb\t\\c" {
+		t.Fatalf("expected the path to be shipped as it is, but got %v", report)
+	}
+}
+
+func TestShipper_dropsRejectedLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	a := &AccessProf{Shipper: &Shipper{URL: server.URL}}
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, AccessedAt: time.Now()})
+	if err := a.flushLogs(); err == nil {
+		t.Fatal("expected an error of the rejected logs")
+	}
+	if a.Count() != 0 || a.Shipper.Dropped() != 1 {
+		t.Fatalf("rejected logs should be dropped, but got %d logs and %d dropped", a.Count(), a.Shipper.Dropped())
+	}
+}
+
+func TestShipper_MaxRetryLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	a := &AccessProf{Shipper: &Shipper{URL: server.URL, MaxRetryLogs: 2}, FlushThreshold: 100}
+	for i := 0; i < 5; i++ {
+		a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, AccessedAt: time.Now()})
+	}
+	a.flushLogs()
+	if a.Count() != 2 || a.Shipper.Dropped() != 3 {
+		t.Fatalf("expected 2 logs to retry and 3 dropped, but got %d and %d", a.Count(), a.Shipper.Dropped())
+	}
+}
+
+func TestShipper_timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := &Shipper{URL: server.URL, Client: &http.Client{Timeout: 50 * time.Millisecond}}
+	a := &AccessProf{Shipper: s}
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, AccessedAt: time.Now()})
+	done := make(chan struct{})
+	go func() {
+		a.Report(nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Report should not wait for the collector")
+	}
+	if err := a.flushLogs(); err == nil {
+		t.Fatal("expected an error of the timeout")
+	}
+}
+
+func TestAccessProf_Close(t *testing.T) {
+	var collector AccessProf
+	server := httptest.NewServer(collector.CollectorHandler())
+	defer server.Close()
+
+	a := &AccessProf{Shipper: &Shipper{URL: server.URL}, FlushInterval: 10 * time.Millisecond}
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, AccessedAt: time.Now()})
+	deadline := time.Now().Add(time.Second)
+	for collector.Count() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if collector.Count() != 1 {
+		t.Fatalf("logs should be shipped every FlushInterval, but the collector got %d logs", collector.Count())
+	}
+
+	a.Record(&AccessLog{Method: "GET", Path: "/users", Status: 200, AccessedAt: time.Now()})
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if collector.Count() != 2 || a.Count() != 0 {
+		t.Fatalf("Close should ship the remaining logs, but the collector got %d logs and %d remain", collector.Count(), a.Count())
+	}
+}
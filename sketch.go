@@ -0,0 +1,157 @@
+package accessprof
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sketchRelativeAccuracy is the relative error of quantiles estimated by latencySketch.
+const sketchRelativeAccuracy = 0.01
+
+var (
+	sketchGamma    = (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// latencySketch is a quantile sketch of response times.
+// Response times are counted in logarithmic bins, so that the estimated quantiles have bounded relative error
+// regardless of the number of recorded values, and sketches of different processes can be merged.
+type latencySketch struct {
+	bins  map[int]int64
+	zero  int64
+	count int64
+}
+
+func sketchIndex(d time.Duration) int {
+	return int(math.Ceil(math.Log(float64(d)) / sketchLogGamma))
+}
+
+func sketchValue(index int) time.Duration {
+	return time.Duration(2 * math.Pow(sketchGamma, float64(index)) / (sketchGamma + 1))
+}
+
+func (s *latencySketch) add(d time.Duration) {
+	s.count++
+	if d <= 0 {
+		s.zero++
+		return
+	}
+	if s.bins == nil {
+		s.bins = map[int]int64{}
+	}
+	s.bins[sketchIndex(d)]++
+}
+
+// merge adds the counts of o to s.
+// Sketches share the same bins, so the merged sketch keeps the relative accuracy.
+func (s *latencySketch) merge(o *latencySketch) {
+	s.count += o.count
+	s.zero += o.zero
+	if len(o.bins) != 0 && s.bins == nil {
+		s.bins = make(map[int]int64, len(o.bins))
+	}
+	for i, n := range o.bins {
+		s.bins[i] += n
+	}
+}
+
+// remove undoes add(d).
+func (s *latencySketch) remove(d time.Duration) {
+	s.count--
+	if d <= 0 {
+		s.zero--
+		return
+	}
+	i := sketchIndex(d)
+	if s.bins[i]--; s.bins[i] == 0 {
+		delete(s.bins, i)
+	}
+}
+
+func (s *latencySketch) indices() []int {
+	indices := make([]int, 0, len(s.bins))
+	for i := range s.bins {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// quantile returns the approximate q-quantile (0 <= q <= 1) by the nearest rank,
+// so that e.g. the p99 of 50 requests is the slowest one rather than the second slowest.
+func (s *latencySketch) quantile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	// the epsilon keeps q*count from rounding up by floating point errors (e.g. 0.95*100)
+	rank := int64(math.Ceil(q*float64(s.count)-1e-9)) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank > s.count-1 {
+		rank = s.count - 1
+	}
+	n := s.zero
+	if rank < n {
+		return 0
+	}
+	for _, i := range s.indices() {
+		n += s.bins[i]
+		if rank < n {
+			return sketchValue(i)
+		}
+	}
+	return 0
+}
+
+// countAtMost returns the approximate number of values less than or equal to d.
+func (s *latencySketch) countAtMost(d time.Duration) int64 {
+	n := s.zero
+	for i, c := range s.bins {
+		if sketchValue(i) <= d {
+			n += c
+		}
+	}
+	return n
+}
+
+type savedSketch struct {
+	Zero int64            `json:"zero"`
+	Bins map[string]int64 `json:"bins"`
+}
+
+func (s *latencySketch) MarshalJSON() ([]byte, error) {
+	saved := savedSketch{Zero: s.zero, Bins: make(map[string]int64, len(s.bins))}
+	for i, n := range s.bins {
+		saved.Bins[strconv.Itoa(i)] = n
+	}
+	return json.Marshal(saved)
+}
+
+func (s *latencySketch) UnmarshalJSON(data []byte) error {
+	var saved savedSketch
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	if saved.Zero < 0 {
+		return errors.Errorf("negative count %d of zero latencies", saved.Zero)
+	}
+	s.zero, s.count, s.bins = saved.Zero, saved.Zero, map[int]int64{}
+	for k, n := range saved.Bins {
+		i, err := strconv.Atoi(k)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.Errorf("negative count %d in bin %d", n, i)
+		}
+		s.bins[i] = n
+		s.count += n
+	}
+	return nil
+}
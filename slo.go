@@ -0,0 +1,150 @@
+package accessprof
+
+import (
+	"fmt"
+	"time"
+)
+
+// SLO is a set of latency targets used for Apdex scores and SLO compliance of reports.
+type SLO struct {
+	// Target is the default latency target (the T of Apdex).
+	// Requests within Target are satisfied, and requests within 4*Target are tolerating.
+	Target time.Duration `json:"target_nano"`
+	// PathTargets overrides Target for segments by aggregation path (e.g. `/users/\d+` or "/search")
+	PathTargets map[string]time.Duration `json:"path_targets_nano,omitempty"`
+	// Objective is the ratio of good requests (within the target and not 5xx) to keep, e.g. 0.99.
+	// The error budget is not computed if 0.
+	Objective float64 `json:"objective,omitempty"`
+}
+
+// TargetOf returns the latency target of the aggregation path.
+func (s *SLO) TargetOf(path string) time.Duration {
+	if t, ok := s.PathTargets[path]; ok {
+		return t
+	}
+	return s.Target
+}
+
+// SetSLO sets the latency targets to compute the Apdex and SLO columns of the report.
+// Reports made by AccessProf use AccessProf.SLO.
+func (r *Report) SetSLO(slo *SLO) {
+	r.slo = slo
+	for _, seg := range r.Segments {
+		seg.slo = slo
+	}
+}
+
+// SLO returns the latency targets of the Apdex and SLO columns of the report (nil if not set).
+func (r *Report) SLO() *SLO {
+	return r.slo
+}
+
+// sloCounts counts satisfied, tolerating and good requests. Counts are approximated by the latency sketch.
+type sloCounts struct {
+	count, satisfied, tolerating, good int
+}
+
+func (c *sloCounts) add(seg *ReportSegment) {
+	seg.ensureStats()
+	target := seg.slo.TargetOf(seg.AggregationPath())
+	satisfied := int(seg.latency.countAtMost(target))
+	c.count += seg.count
+	c.satisfied += satisfied
+	c.tolerating += int(seg.latency.countAtMost(4*target)) - satisfied
+	if seg.Status < 500 {
+		c.good += satisfied
+	}
+}
+
+func (c *sloCounts) apdex() float64 {
+	if c.count == 0 {
+		return 0
+	}
+	return (float64(c.satisfied) + float64(c.tolerating)/2) / float64(c.count)
+}
+
+func (c *sloCounts) withinTarget() float64 {
+	if c.count == 0 {
+		return 0
+	}
+	return float64(c.satisfied) / float64(c.count)
+}
+
+// errorBudget returns the ratio of the error budget remaining (negative if the budget is exhausted).
+func (c *sloCounts) errorBudget(objective float64) float64 {
+	allowed := (1 - objective) * float64(c.count)
+	bad := float64(c.count - c.good)
+	if allowed <= 0 {
+		if bad == 0 {
+			return 1
+		}
+		return 0
+	}
+	return 1 - bad/allowed
+}
+
+func (seg *ReportSegment) sloCounts() *sloCounts {
+	c := new(sloCounts)
+	if seg.slo != nil {
+		c.add(seg)
+	}
+	return c
+}
+
+// Apdex returns the Apdex score of the segment (0 if the report has no SLO).
+func (seg *ReportSegment) Apdex() float64 {
+	return seg.sloCounts().apdex()
+}
+
+// WithinTarget returns the ratio of requests within the latency target (0 if the report has no SLO).
+func (seg *ReportSegment) WithinTarget() float64 {
+	return seg.sloCounts().withinTarget()
+}
+
+// ErrorBudget returns the ratio of the error budget remaining in the segment (0 if the report has no SLO objective).
+// All requests of 5xx segments are bad.
+func (seg *ReportSegment) ErrorBudget() float64 {
+	if seg.slo == nil || seg.slo.Objective == 0 {
+		return 0
+	}
+	return seg.sloCounts().errorBudget(seg.slo.Objective)
+}
+
+func (r *Report) inboundSLOCounts() *sloCounts {
+	c := new(sloCounts)
+	if r.slo == nil {
+		return c
+	}
+	for _, seg := range r.InboundSegments() {
+		c.add(seg)
+	}
+	return c
+}
+
+// Apdex returns the Apdex score of all inbound requests (0 if the report has no SLO).
+func (r *Report) Apdex() float64 {
+	return r.inboundSLOCounts().apdex()
+}
+
+// ErrorBudget returns the ratio of the error budget remaining over all inbound requests
+// (0 if the report has no SLO objective).
+func (r *Report) ErrorBudget() float64 {
+	if r.slo == nil || r.slo.Objective == 0 {
+		return 0
+	}
+	return r.inboundSLOCounts().errorBudget(r.slo.Objective)
+}
+
+// sloSummary formats the SLO compliance of the report for the header of reports (empty if the report has no SLO).
+func (r *Report) sloSummary() string {
+	if r.slo == nil {
+		return ""
+	}
+	c := r.inboundSLOCounts()
+	s := fmt.Sprintf("SLO: Apdex %.2f, %s within target (%s)", c.apdex(), formatPercent(c.withinTarget()), r.slo.Target)
+	if r.slo.Objective != 0 {
+		s += fmt.Sprintf(", %s of error budget remaining for %s objective",
+			formatPercent(c.errorBudget(r.slo.Objective)), formatPercent(r.slo.Objective))
+	}
+	return s
+}
@@ -0,0 +1,213 @@
+package accessprof
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/agatan/timejump"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxSnapshots is the number of snapshots kept if AccessProf.MaxSnapshots is 0.
+const DefaultMaxSnapshots = 10
+
+// Snapshot is a named window of access logs cut from AccessProf.
+type Snapshot struct {
+	Name      string
+	CreatedAt time.Time
+	// accessLogs are the logs of the snapshot if AccessProf has no LogFile
+	accessLogs []*AccessLog
+	// logFile holds the logs of the snapshot if AccessProf has LogFile, so that they are not kept in memory
+	logFile string
+	count   int
+	prof    *AccessProf
+}
+
+// Report aggregates the access logs of the snapshot.
+func (s *Snapshot) Report(aggregates []*regexp.Regexp) *Report {
+	logs := s.accessLogs
+	if s.logFile != "" {
+		var err error
+		if logs, err = readLogFile(s.logFile); err != nil {
+			panic(err)
+		}
+	}
+	return buildReport(logs, aggregates, s.prof.reportOptions())
+}
+
+// Count returns the number of access logs in the snapshot.
+func (s *Snapshot) Count() int {
+	return s.count
+}
+
+// Cut moves all access logs recorded so far into a new snapshot named name and starts a fresh window.
+// If name is empty, the creation time is used as the name.
+// With LogFile, the logs of the snapshot stay in a file next to LogFile instead of in memory.
+// The oldest snapshots beyond MaxSnapshots are deleted.
+func (a *AccessProf) Cut(name string) (*Snapshot, error) {
+	now := timejump.Now()
+	if name == "" {
+		name = now.Format(time.RFC3339)
+	}
+
+	// flushMu serializes Cut, so that no other snapshot named name can be appended
+	// between the check and the append below.
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+	if a.Snapshot(name) != nil {
+		return nil, errors.Errorf("snapshot %q already exists", name)
+	}
+
+	s := &Snapshot{Name: name, CreatedAt: now, prof: a}
+	if a.LogFile == "" {
+		s.accessLogs = a.takeLogs()
+		s.count = len(s.accessLogs)
+	} else {
+		a.snapshotSeq++
+		s.logFile = fmt.Sprintf("%s.snapshot-%d", a.LogFile, a.snapshotSeq)
+		count, err := a.moveLogs(s.logFile)
+		if err != nil {
+			return nil, err
+		}
+		s.count = count
+	}
+
+	max := a.MaxSnapshots
+	if max <= 0 {
+		max = DefaultMaxSnapshots
+	}
+	a.mu.Lock()
+	a.snapshots = append(a.snapshots, s)
+	var deleted []*Snapshot
+	if len(a.snapshots) > max {
+		deleted = a.snapshots[:len(a.snapshots)-max]
+		a.snapshots = append([]*Snapshot(nil), a.snapshots[len(a.snapshots)-max:]...)
+	}
+	a.mu.Unlock()
+	for _, d := range deleted {
+		d.remove()
+	}
+	return s, nil
+}
+
+// DeleteSnapshot deletes the snapshot named name and its logs.
+func (a *AccessProf) DeleteSnapshot(name string) error {
+	a.mu.Lock()
+	var deleted *Snapshot
+	for i, s := range a.snapshots {
+		if s.Name == name {
+			deleted = s
+			a.snapshots = append(a.snapshots[:i:i], a.snapshots[i+1:]...)
+			break
+		}
+	}
+	a.mu.Unlock()
+	if deleted == nil {
+		return errors.Errorf("snapshot %q is not found", name)
+	}
+	return deleted.remove()
+}
+
+// remove removes the log file of the snapshot, if any.
+func (s *Snapshot) remove() error {
+	if s.logFile == "" {
+		return nil
+	}
+	if err := os.Remove(s.logFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove the logs of the snapshot")
+	}
+	return nil
+}
+
+// Snapshots returns all snapshots in the order they were cut.
+func (a *AccessProf) Snapshots() []*Snapshot {
+	a.mu.Lock()
+	snapshots := make([]*Snapshot, len(a.snapshots))
+	copy(snapshots, a.snapshots)
+	a.mu.Unlock()
+	return snapshots
+}
+
+// Snapshot returns the snapshot named name, or nil if there is no such snapshot.
+func (a *AccessProf) Snapshot(name string) *Snapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range a.snapshots {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// takeLogs removes all access logs in memory, and returns them.
+func (a *AccessProf) takeLogs() []*AccessLog {
+	a.mu.Lock()
+	logs := a.accessLogs
+	a.accessLogs = nil
+	a.recorded = recordedValues{}
+	a.mu.Unlock()
+	return logs
+}
+
+// moveLogs moves all access logs in memory and in LogFile to path, and returns the number of them.
+// LogFile is renamed rather than read, so that the logs are never loaded into memory at once.
+// The caller must hold flushMu.
+func (a *AccessProf) moveLogs(path string) (int, error) {
+	logs := a.takeLogs()
+	restore := func() {
+		a.mu.Lock()
+		a.accessLogs = append(logs, a.accessLogs...)
+		a.mu.Unlock()
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if err := os.Rename(a.LogFile, path); err != nil {
+		if !os.IsNotExist(err) {
+			restore()
+			return 0, errors.Wrap(err, "failed to rename log file")
+		}
+		// no logs are flushed yet; don't append to a stale file of a previous process
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		restore()
+		return 0, errors.Wrap(err, "failed to open the log file of the snapshot")
+	}
+	w := bufio.NewWriter(f)
+	for _, l := range logs {
+		if err = l.writeLTSV(w); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// the logs in memory may be partially written; keep them in the window too rather than losing them
+		restore()
+		return 0, errors.Wrap(err, "failed to write the logs of the snapshot")
+	}
+	return countLines(path)
+}
+
+// countLines returns the number of logs in the log file at path without parsing them.
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open access logs")
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	n := 0
+	for s.Scan() {
+		n++
+	}
+	return n, errors.Wrap(s.Err(), "failed to read access logs")
+}
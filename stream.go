@@ -0,0 +1,140 @@
+package accessprof
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultStreamInterval is the interval of events sent by the live report stream.
+// It can be changed for each stream by the interval query parameter (in seconds).
+const DefaultStreamInterval = 2 * time.Second
+
+// liveReport is the payload of events sent by the live report stream.
+// Rows are formatted in the same way as the rows of the HTML report, so that the page can replace its tables in place.
+// The cells of rows are HTML-escaped, since DataTables renders them as HTML.
+type liveReport struct {
+	RequestCount    int        `json:"request_count"`
+	Since           string     `json:"since"`
+	Rows            [][]string `json:"rows"`
+	OutboundRows    [][]string `json:"outbound_rows"`
+	Overflowed      int        `json:"overflowed"`
+	StatusBreakdown string     `json:"status_breakdown"`
+	Throughput      string     `json:"throughput"`
+	SLOSummary      string     `json:"slo_summary"`
+	SummaryRows     [][]string `json:"summary_rows"`
+	// Histograms are the latency histograms of the rows, keyed by the table id
+	Histograms map[string][][]HistogramBucket `json:"histograms"`
+	Slowest    map[string][][]*slowLog        `json:"slowest"`
+}
+
+// serveEvents streams the report of the current window as Server-Sent Events until the client goes away.
+func (a *reportHandler) serveEvents(w http.ResponseWriter, r *http.Request, aggs []*regexp.Regexp) {
+	interval := DefaultStreamInterval
+	if s := r.URL.Query().Get("interval"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid interval %q", s)
+			return
+		}
+		interval = time.Duration(n) * time.Second
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		body, err := a.liveEvent(aggs, interval)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: report\ndata: %s\n\n", body); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// liveEvents caches the latest payload of the live report stream for each set of aggregates,
+// so that clients watching the same report share a single report for each tick.
+type liveEvents struct {
+	mu     sync.Mutex
+	events map[string]*liveEvent
+}
+
+type liveEvent struct {
+	body    []byte
+	builtAt time.Time
+}
+
+// liveEvent returns the payload of the live report of aggs, built within maxAge.
+func (a *AccessProf) liveEvent(aggs []*regexp.Regexp, maxAge time.Duration) ([]byte, error) {
+	key := (&Report{Aggregates: aggs}).aggregatesParam()
+	a.live.mu.Lock()
+	defer a.live.mu.Unlock()
+	now := time.Now()
+	if e, ok := a.live.events[key]; ok && now.Sub(e.builtAt) < maxAge {
+		return e.body, nil
+	}
+	body, err := json.Marshal(newLiveReport(a.Report(aggs)))
+	if err != nil {
+		return nil, err
+	}
+	if a.live.events == nil {
+		a.live.events = map[string]*liveEvent{}
+	}
+	for k, e := range a.live.events {
+		if now.Sub(e.builtAt) >= maxAge {
+			delete(a.live.events, k)
+		}
+	}
+	a.live.events[key] = &liveEvent{body: body, builtAt: now}
+	return body, nil
+}
+
+func newLiveReport(r *Report) *liveReport {
+	live := &liveReport{
+		RequestCount: r.RequestCount(),
+		Since:        r.Since.Format(time.RFC3339Nano),
+		Overflowed:   r.Overflowed,
+	}
+	live.Rows, live.OutboundRows = r.htmlRows()
+	escapeCells(live.Rows)
+	escapeCells(live.OutboundRows)
+	live.Histograms = r.htmlHistograms()
+	live.Slowest = r.htmlSlowest()
+	live.StatusBreakdown = r.StatusSummary().breakdown()
+	live.Throughput = r.throughput()
+	live.SLOSummary = r.sloSummary()
+	for _, s := range r.StatusSummaries() {
+		live.SummaryRows = append(live.SummaryRows, s.cells())
+	}
+	return live
+}
+
+func escapeCells(rows [][]string) {
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = html.EscapeString(cell)
+		}
+	}
+}
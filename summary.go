@@ -0,0 +1,101 @@
+package accessprof
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StatusSummary counts responses of a method and aggregation path (or of a whole report) across statuses.
+type StatusSummary struct {
+	Method string
+	Path   string
+	// Outbound and Host are the same as ReportSegment
+	Outbound bool
+	Host     string
+	Count    int
+	// ByClass counts responses by status class: ByClass[2] for 2xx, ..., ByClass[5] for 5xx.
+	// ByClass[0] counts outbound requests which failed without a response,
+	// and ByClass[1] counts 1xx responses such as 101 Switching Protocols.
+	ByClass [6]int
+}
+
+func (s *StatusSummary) add(seg *ReportSegment) {
+	class := seg.Status / 100
+	if class < 0 || class >= len(s.ByClass) {
+		class = 0
+	}
+	s.ByClass[class] += seg.Count()
+	s.Count += seg.Count()
+}
+
+// ErrorRate returns the ratio of 5xx responses.
+func (s *StatusSummary) ErrorRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.ByClass[5]) / float64(s.Count)
+}
+
+// Other returns the number of responses which are neither 2xx, 3xx, 4xx nor 5xx.
+func (s *StatusSummary) Other() int {
+	return s.ByClass[0] + s.ByClass[1]
+}
+
+// StatusSummaries returns summaries for each method and aggregation path (and destination of outbound requests),
+// since statuses of the same path are split into separate segments.
+func (r *Report) StatusSummaries() []*StatusSummary {
+	var summaries []*StatusSummary
+	index := map[string]*StatusSummary{}
+	for _, seg := range r.Segments {
+		key := strings.Join([]string{strconv.FormatBool(seg.Outbound), seg.Host, seg.Method, seg.AggregationPath()}, " ")
+		s, ok := index[key]
+		if !ok {
+			s = &StatusSummary{Method: seg.Method, Path: seg.AggregationPath(), Outbound: seg.Outbound, Host: seg.Host}
+			index[key] = s
+			summaries = append(summaries, s)
+		}
+		s.add(seg)
+	}
+	return summaries
+}
+
+// StatusSummary returns the summary of all inbound requests.
+func (r *Report) StatusSummary() *StatusSummary {
+	s := new(StatusSummary)
+	for _, seg := range r.InboundSegments() {
+		s.add(seg)
+	}
+	return s
+}
+
+// breakdown formats the summary like "2xx: 8, 3xx: 0, 4xx: 1, 5xx: 1, other: 0, errors: 10.00%".
+func (s *StatusSummary) breakdown() string {
+	parts := make([]string, 0, 6)
+	for class := 2; class <= 5; class++ {
+		parts = append(parts, strconv.Itoa(class)+"xx: "+strconv.Itoa(s.ByClass[class]))
+	}
+	parts = append(parts, "other: "+strconv.Itoa(s.Other()))
+	parts = append(parts, "errors: "+formatPercent(s.ErrorRate()))
+	return strings.Join(parts, ", ")
+}
+
+// cells returns the cells of a row of summary tables.
+func (s *StatusSummary) cells() []string {
+	return []string{
+		s.Method,
+		s.Host + s.Path,
+		strconv.Itoa(s.Count),
+		strconv.Itoa(s.ByClass[2]),
+		strconv.Itoa(s.ByClass[3]),
+		strconv.Itoa(s.ByClass[4]),
+		strconv.Itoa(s.ByClass[5]),
+		strconv.Itoa(s.Other()),
+		formatPercent(s.ErrorRate()),
+	}
+}
+
+var summaryHeader = []string{"METHOD", "PATH", "COUNT", "2XX", "3XX", "4XX", "5XX", "OTHER", "ERRORS"}
+
+func formatPercent(rate float64) string {
+	return strconv.FormatFloat(rate*100, 'f', 2, 64) + "%"
+}
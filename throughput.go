@@ -0,0 +1,89 @@
+package accessprof
+
+import (
+	"fmt"
+	"time"
+)
+
+// Duration returns the observed time window of the report,
+// from the arrival of the first request to the end of the last one.
+func (r *Report) Duration() time.Duration {
+	if r.Since.IsZero() || r.Until.Before(r.Since) {
+		return 0
+	}
+	return r.Until.Sub(r.Since)
+}
+
+// setWindow lets segments compute their throughput over the time window of the report.
+// It must be called whenever Since or Until changes.
+func (r *Report) setWindow() {
+	for _, seg := range r.Segments {
+		seg.window = r.Duration()
+	}
+}
+
+// RequestsPerSecond returns the throughput of inbound requests over the time window (0 if the window is empty).
+func (r *Report) RequestsPerSecond() float64 {
+	var n int
+	for _, seg := range r.InboundSegments() {
+		n += seg.Count()
+	}
+	return perSecond(float64(n), r.Duration())
+}
+
+// MaxConcurrency returns the maximum number of inbound requests served at the same time.
+func (r *Report) MaxConcurrency() int {
+	var max int
+	for _, seg := range r.InboundSegments() {
+		if seg.MaxConcurrency() > max {
+			max = seg.MaxConcurrency()
+		}
+	}
+	return max
+}
+
+// AvgConcurrency returns the average number of inbound requests served at the same time over the time window,
+// i.e. the sum of response times divided by the window.
+func (r *Report) AvgConcurrency() float64 {
+	var sum time.Duration
+	for _, seg := range r.InboundSegments() {
+		sum += seg.SumResponseTime()
+	}
+	return perSecond(sum.Seconds(), r.Duration())
+}
+
+// RequestsPerSecond returns the throughput of the segment over the time window of the report.
+func (seg *ReportSegment) RequestsPerSecond() float64 {
+	return perSecond(float64(seg.Count()), seg.window)
+}
+
+// MaxConcurrency returns the maximum number of requests being served by Handler when requests of the segment arrived
+// (0 if unknown, e.g. for outbound requests and gRPC calls).
+func (seg *ReportSegment) MaxConcurrency() int {
+	seg.ensureStats()
+	return seg.maxInFlight
+}
+
+// AvgConcurrency returns the average number of requests of the segment being served at the same time
+// over the time window of the report.
+func (seg *ReportSegment) AvgConcurrency() float64 {
+	return perSecond(seg.SumResponseTime().Seconds(), seg.window)
+}
+
+// throughput formats the throughput and concurrency of the report for the header of reports.
+// The maximum concurrency is omitted if no request was tracked in flight, e.g. in reports of gRPC calls.
+func (r *Report) throughput() string {
+	if r.MaxConcurrency() == 0 {
+		return fmt.Sprintf("THROUGHPUT: %.2f req/s over %s (concurrency avg %.2f)",
+			r.RequestsPerSecond(), r.Duration(), r.AvgConcurrency())
+	}
+	return fmt.Sprintf("THROUGHPUT: %.2f req/s over %s (concurrency max %d, avg %.2f)",
+		r.RequestsPerSecond(), r.Duration(), r.MaxConcurrency(), r.AvgConcurrency())
+}
+
+func perSecond(n float64, window time.Duration) float64 {
+	if window <= 0 {
+		return 0
+	}
+	return n / window.Seconds()
+}
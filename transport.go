@@ -0,0 +1,83 @@
+package accessprof
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/agatan/timejump"
+)
+
+// WrapTransport returns an http.RoundTripper which records outbound requests sent through rt.
+// If rt is nil, http.DefaultTransport is used.
+//
+// The response time of an outbound request is measured until its response body is read to the end or closed,
+// so a request whose response body is neither read to the end nor closed is never recorded
+// (the caller must close response bodies anyway, see http.Response).
+// Responses of 101 Switching Protocols are recorded when their headers are received,
+// and their bodies are passed through as they are, so that upgraded connections stay writable.
+func (a *AccessProf) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &transport{base: rt, prof: a}
+}
+
+type transport struct {
+	base http.RoundTripper
+	prof *AccessProf
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := &AccessLog{
+		Method:          req.Method,
+		Path:            req.URL.Path,
+		RequestBodySize: req.ContentLength,
+		AccessedAt:      timejump.Now(),
+		Outbound:        true,
+		Host:            req.URL.Host,
+	}
+	start := timejump.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		// Failed requests are recorded with status 0.
+		l.ResponseTime = timejump.Now().Sub(start)
+		t.prof.Record(l)
+		return nil, err
+	}
+	l.Status = resp.StatusCode
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		l.ResponseTime = timejump.Now().Sub(start)
+		t.prof.Record(l)
+		return resp, nil
+	}
+	resp.Body = &responseBody{ReadCloser: resp.Body, done: func(n int) {
+		l.ResponseTime = timejump.Now().Sub(start)
+		l.ResponseBodySize = n
+		t.prof.Record(l)
+	}}
+	return resp, nil
+}
+
+// responseBody counts bytes read from the body, and calls done once when it reaches EOF or is closed.
+type responseBody struct {
+	io.ReadCloser
+	n    int
+	once sync.Once
+	done func(n int)
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += n
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.n) })
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
@@ -0,0 +1,79 @@
+package accessprof
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAccessProf_WrapTransport(t *testing.T) {
+	var a AccessProf
+	upstream := httptest.NewServer(testHandler)
+	defer upstream.Close()
+	server := httptest.NewServer(a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := &http.Client{Transport: a.WrapTransport(nil)}
+		resp, err := client.Get(upstream.URL + "/upstream")
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}), ""))
+	defer server.Close()
+
+	http.Get(server.URL + "/inbound")
+	http.Get(server.URL + "/inbound")
+
+	report := a.Report(nil)
+	if len(report.InboundSegments()) != 1 || len(report.OutboundSegments()) != 1 {
+		t.Fatalf("expected an inbound and an outbound segment, but got %v", report.Segments)
+	}
+	seg := report.OutboundSegments()[0]
+	u, _ := url.Parse(upstream.URL)
+	if seg.Host != u.Host || seg.Path != "/upstream" || seg.Count() != 2 || seg.MinBody() != len("Path: /upstream\n") {
+		t.Fatalf("unexpected outbound segment: %+v", seg)
+	}
+	if !strings.Contains(report.String(), "OUTBOUND") {
+		t.Fatalf("outbound segments should be rendered in a separate table:\n%s", report)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type upgradedConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (upgradedConn) Close() error {
+	return nil
+}
+
+func TestAccessProf_WrapTransport_switchingProtocols(t *testing.T) {
+	var a AccessProf
+	conn := upgradedConn{Reader: strings.NewReader(""), Writer: ioutil.Discard}
+	rt := a.WrapTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusSwitchingProtocols, Body: conn, Request: req}, nil
+	}))
+
+	req, _ := http.NewRequest("GET", "http://example.com/ws", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Body.(io.Writer); !ok {
+		t.Fatal("the body of 101 Switching Protocols should be writable")
+	}
+	if a.Count() != 1 {
+		t.Fatalf("101 Switching Protocols should be recorded when the headers are received, but got %d logs", a.Count())
+	}
+}